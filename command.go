@@ -0,0 +1,438 @@
+package readline
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Command declares the syntax of a command: its subcommands, flags and
+// positional arguments. The lines typed at the prompt can then be completed
+// (a Command implements AutoCompleter and RichCompleter) and parsed
+// according to it. The words of a line are split and unquoted the way a
+// POSIX shell does.
+//
+// The root Command describes the whole line: its Name is not typed, so it
+// typically only has Subcommands, or Flags and Args.
+type Command struct {
+	Name        string
+	Aliases     []string
+	Description string // displayed next to the name when completing it
+	Subcommands []*Command
+	// Flags are the flags of the command; they are also accepted after the
+	// name of any of its subcommands.
+	Flags []*Flag
+	// Args are the positional arguments of the command, which follow the
+	// flags; flags can also be interleaved with them, unless separated by
+	// "--". If the command has both Subcommands and Args, the first
+	// positional argument can not be the name of a subcommand.
+	Args []*Arg
+}
+
+// Flag declares a flag of a Command. Flags are typed as --name, or -s for
+// the short name; short boolean flags can be combined (-abc). Flags that
+// take a value accept the forms --name=value, --name value, -svalue and
+// -s value.
+type Flag struct {
+	Name        string // long name, without the leading "--"
+	Short       rune   // optional short name, without the leading "-"
+	Description string // displayed next to the name when completing it
+	// TakesValue is whether the flag takes a value; other flags are boolean.
+	TakesValue bool
+	// Repeatable is whether the flag can be given several times.
+	Repeatable bool
+	// Type, Choices and Complete apply to the value of the flag, as for Arg.
+	Type     ArgType
+	Choices  []string
+	Complete ArgCompleteFunc
+}
+
+// Arg declares a positional argument of a Command.
+type Arg struct {
+	Name        string
+	Description string
+	// Type is the type of the value, which is checked by Command.Parse.
+	Type ArgType
+	// Choices, if set, are the valid values, which are completed.
+	Choices []string
+	// Complete, if set, completes the value, in addition to Choices.
+	Complete ArgCompleteFunc
+	// Optional is whether the argument can be omitted; the arguments after
+	// an optional argument must be optional too.
+	Optional bool
+	// Variadic is whether the argument consumes all the remaining positional
+	// arguments; only the last argument can be variadic.
+	Variadic bool
+}
+
+// ArgType is the type of the value of an Arg or Flag.
+type ArgType int
+
+const (
+	ArgString ArgType = iota
+	ArgInt
+	ArgFloat
+)
+
+// ArgCompleteFunc returns the candidates for the value of an argument or of
+// a flag. The candidates that start with ctx.Word are inserted, quoted as
+// necessary: their Text must not be quoted. The Suffix of the candidates
+// is kept, so it is typically " ".
+type ArgCompleteFunc func(ctx *ArgContext) []Candidate
+
+// ArgContext describes the value being completed by an ArgCompleteFunc:
+// ParsedCommand is the result of parsing the words before it.
+type ArgContext struct {
+	ParsedCommand
+	// ArgIndex is the index in Command.Args of the argument whose value is
+	// being completed, or -1 if it is the value of a flag. All the values
+	// of a Variadic last argument have its index.
+	ArgIndex int
+	// Position is the index of the value being completed among the
+	// positional arguments (i.e. len(Args)), or -1 if it is the value of a
+	// flag.
+	Position int
+	// Flag is the flag whose value is being completed, or nil.
+	Flag *Flag
+	// Word is the (unquoted) part of the value typed so far.
+	Word string
+}
+
+// ParsedCommand is the result of Command.Parse.
+type ParsedCommand struct {
+	// Command is the command that was invoked, i.e. the last subcommand.
+	Command *Command
+	// Path is the names (not the aliases) of the subcommands that were typed.
+	Path []string
+	// Flags holds the values of the flags that were given, by name (or by
+	// short name, for flags with no name); the value of boolean flags is
+	// "true".
+	Flags map[string][]string
+	// Args holds the positional arguments.
+	Args []string
+}
+
+// Bool returns whether the flag was given.
+func (p *ParsedCommand) Bool(name string) bool {
+	return len(p.Flags[name]) != 0
+}
+
+// Value returns the last value of the flag, or "" if it was not given.
+func (p *ParsedCommand) Value(name string) string {
+	if values := p.Flags[name]; len(values) != 0 {
+		return values[len(values)-1]
+	}
+	return ""
+}
+
+// Values returns the values of a repeatable flag.
+func (p *ParsedCommand) Values(name string) []string {
+	return p.Flags[name]
+}
+
+func (f *Flag) key() string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return string(f.Short)
+}
+
+func (f *Flag) String() string {
+	if f.Name != "" {
+		return "--" + f.Name
+	}
+	return "-" + string(f.Short)
+}
+
+// validateArg checks a value of an argument or flag.
+func validateArg(name string, typ ArgType, choices []string, value string) error {
+	switch typ {
+	case ArgInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("invalid value %q for %s: expected an integer", value, name)
+		}
+	case ArgFloat:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("invalid value %q for %s: expected a number", value, name)
+		}
+	}
+	if len(choices) != 0 {
+		for _, choice := range choices {
+			if value == choice {
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid value %q for %s: expected one of %s", value, name, strings.Join(choices, ", "))
+	}
+	return nil
+}
+
+// subcommand returns the subcommand with the given name or alias.
+func (c *Command) subcommand(name string) *Command {
+	for _, sub := range c.Subcommands {
+		if sub.Name == name {
+			return sub
+		}
+		for _, alias := range sub.Aliases {
+			if alias == name {
+				return sub
+			}
+		}
+	}
+	return nil
+}
+
+// arg returns the declaration of the positional argument at index i.
+func (c *Command) arg(i int) *Arg {
+	if i < len(c.Args) {
+		return c.Args[i]
+	}
+	if n := len(c.Args); n != 0 && c.Args[n-1].Variadic {
+		return c.Args[n-1]
+	}
+	return nil
+}
+
+// commandParser holds the state of the parsing of the words of a line.
+type commandParser struct {
+	ParsedCommand
+	chain    []*Command // the commands whose flags are accepted
+	pending  *Flag      // flag whose value is the next word
+	dashdash bool       // whether "--" ended the flags
+	err      error      // the first error
+}
+
+func newCommandParser(root *Command) *commandParser {
+	return &commandParser{
+		ParsedCommand: ParsedCommand{Command: root, Flags: make(map[string][]string)},
+		chain:         []*Command{root},
+	}
+}
+
+func (p *commandParser) fail(format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf(format, args...)
+	}
+}
+
+// lookupFlag returns the flag with the given long name, or short name if
+// name is empty.
+func (p *commandParser) lookupFlag(name string, short rune) *Flag {
+	for i := len(p.chain) - 1; i >= 0; i-- {
+		for _, f := range p.chain[i].Flags {
+			if (name != "" && f.Name == name) || (name == "" && f.Short != 0 && f.Short == short) {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+func (p *commandParser) addFlag(f *Flag, value string) {
+	key := f.key()
+	if len(p.Flags[key]) != 0 && !f.Repeatable {
+		p.fail("flag %s given more than once", f)
+	}
+	if f.TakesValue {
+		if err := validateArg(f.String(), f.Type, f.Choices, value); err != nil && p.err == nil {
+			p.err = err
+		}
+	}
+	p.Flags[key] = append(p.Flags[key], value)
+}
+
+// word consumes the next word of the line.
+func (p *commandParser) word(w string) {
+	if p.pending != nil {
+		p.addFlag(p.pending, w)
+		p.pending = nil
+		return
+	}
+	switch {
+	case !p.dashdash && w == "--":
+		p.dashdash = true
+	case !p.dashdash && strings.HasPrefix(w, "--"):
+		name, value := w[2:], ""
+		i := strings.IndexByte(name, '=')
+		if i != -1 {
+			name, value = name[:i], name[i+1:]
+		}
+		f := p.lookupFlag(name, 0)
+		if f == nil {
+			p.fail("unknown flag --%s", name)
+		} else if !f.TakesValue && i != -1 {
+			p.fail("flag %s does not take a value", f)
+		} else if f.TakesValue && i == -1 {
+			p.pending = f
+		} else if f.TakesValue {
+			p.addFlag(f, value)
+		} else {
+			p.addFlag(f, "true")
+		}
+	case !p.dashdash && len(w) > 1 && w[0] == '-':
+		shorts := []rune(w[1:])
+		for i, r := range shorts {
+			f := p.lookupFlag("", r)
+			if f == nil {
+				p.fail("unknown flag -%c", r)
+				return
+			}
+			if !f.TakesValue {
+				p.addFlag(f, "true")
+				continue
+			}
+			if i+1 < len(shorts) {
+				p.addFlag(f, string(shorts[i+1:]))
+			} else {
+				p.pending = f
+			}
+			return
+		}
+	default:
+		if len(p.Args) == 0 {
+			if sub := p.Command.subcommand(w); sub != nil {
+				p.Command = sub
+				p.Path = append(p.Path, sub.Name)
+				p.chain = append(p.chain, sub)
+				return
+			}
+		}
+		arg := p.Command.arg(len(p.Args))
+		if arg == nil {
+			if len(p.Command.Subcommands) != 0 && len(p.Args) == 0 {
+				p.fail("unknown command %q", w)
+			} else {
+				p.fail("too many arguments")
+			}
+		} else if err := validateArg(arg.Name, arg.Type, arg.Choices, w); err != nil && p.err == nil {
+			p.err = err
+		}
+		p.Args = append(p.Args, w)
+	}
+}
+
+// Parse parses a line according to the declaration of c, and checks that
+// the flags and positional arguments are known and valid.
+func (c *Command) Parse(line string) (*ParsedCommand, error) {
+	p := newCommandParser(c)
+	r := []rune(line)
+	for _, w := range splitWords(r) {
+		p.word(string(unquoteWord(r[w.start:w.end])))
+	}
+	if p.pending != nil {
+		p.fail("flag %s requires a value", p.pending)
+	}
+	for i := len(p.Args); i < len(p.Command.Args); i++ {
+		if arg := p.Command.Args[i]; !arg.Optional && !arg.Variadic {
+			p.fail("missing argument %s", arg.Name)
+			break
+		}
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &p.ParsedCommand, nil
+}
+
+var (
+	_ AutoCompleter = (*Command)(nil)
+	_ RichCompleter = (*Command)(nil)
+)
+
+func (c *Command) Do(line []rune, pos int) (newLine [][]rune, length int) {
+	return completionsToSuffixes(line, pos, c.DoRich(line, pos))
+}
+
+// DoRich implements RichCompleter: it completes the names of subcommands,
+// the names of flags (if the word starts with "-"), and the values of flags
+// and positional arguments.
+func (c *Command) DoRich(line []rune, pos int) (completions Completions) {
+	start, before := wordStart(line, pos)
+	p := newCommandParser(c)
+	for _, w := range before {
+		p.word(string(unquoteWord(line[w.start:w.end])))
+	}
+	word := line[start:pos]
+	completions.Offset = len(word)
+	completions.After = wordEnd(line, pos) - pos
+	quote := wordQuote(word)
+	typed := string(unquoteWord(word))
+
+	// add appends the candidates among values that start with typed,
+	// prefixed with prefix, to the completions
+	add := func(prefix string, values []Candidate) {
+		for _, v := range values {
+			if strings.HasPrefix(v.Text, typed) {
+				v.Text = string(quoteWord([]rune(prefix+v.Text), quote))
+				completions.Candidates = append(completions.Candidates, v)
+			}
+		}
+	}
+	ctx := &ArgContext{ParsedCommand: p.ParsedCommand, ArgIndex: -1, Position: -1, Word: typed}
+	values := func(choices []string, complete ArgCompleteFunc) (values []Candidate) {
+		for _, choice := range choices {
+			values = append(values, Candidate{Text: choice, Suffix: " "})
+		}
+		if complete != nil {
+			values = append(values, complete(ctx)...)
+		}
+		return
+	}
+
+	switch {
+	case p.pending != nil:
+		ctx.Flag = p.pending
+		add("", values(p.pending.Choices, p.pending.Complete))
+	case !p.dashdash && strings.HasPrefix(typed, "--") && strings.Contains(typed, "="):
+		i := strings.IndexByte(typed, '=')
+		f := p.lookupFlag(typed[2:i], 0)
+		if f == nil || !f.TakesValue {
+			return
+		}
+		prefix := typed[:i+1]
+		ctx.Flag = f
+		ctx.Word = typed[i+1:]
+		typed = ctx.Word
+		add(prefix, values(f.Choices, f.Complete))
+	case !p.dashdash && strings.HasPrefix(typed, "-"):
+		var flags []Candidate
+		seen := make(map[string]bool)
+		for i := len(p.chain) - 1; i >= 0; i-- {
+			for _, f := range p.chain[i].Flags {
+				key := f.key()
+				if seen[key] || (len(p.Flags[key]) != 0 && !f.Repeatable) {
+					continue
+				}
+				seen[key] = true
+				candidate := Candidate{Text: f.String(), Description: f.Description, Suffix: " "}
+				if f.TakesValue && f.Name != "" {
+					candidate.Suffix = "="
+				}
+				flags = append(flags, candidate)
+			}
+		}
+		add("", flags)
+	default:
+		if len(p.Args) == 0 {
+			var commands []Candidate
+			for _, sub := range p.Command.Subcommands {
+				commands = append(commands, Candidate{Text: sub.Name, Description: sub.Description, Suffix: " "})
+				// an alias is only completed if it is typed
+				for _, alias := range sub.Aliases {
+					if typed != "" && strings.HasPrefix(alias, typed) && !strings.HasPrefix(sub.Name, typed) {
+						commands = append(commands, Candidate{Text: alias, Description: sub.Description, Suffix: " "})
+					}
+				}
+			}
+			add("", commands)
+		}
+		if arg := p.Command.arg(len(p.Args)); arg != nil {
+			ctx.ArgIndex, ctx.Position = len(p.Args), len(p.Args)
+			if ctx.ArgIndex >= len(p.Command.Args) {
+				ctx.ArgIndex = len(p.Command.Args) - 1 // variadic
+			}
+			add("", values(arg.Choices, arg.Complete))
+		}
+	}
+	return
+}
@@ -0,0 +1,162 @@
+package readline
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newTestCommand(contexts *[]ArgContext) *Command {
+	branches := func(ctx *ArgContext) []Candidate {
+		*contexts = append(*contexts, *ctx)
+		return []Candidate{{Text: "main", Suffix: " "}, {Text: "my branch", Suffix: " "}}
+	}
+	return &Command{
+		Flags: []*Flag{{Name: "verbose", Short: 'v', Description: "log more"}},
+		Subcommands: []*Command{
+			{
+				Name:    "checkout",
+				Aliases: []string{"co"},
+				Flags: []*Flag{
+					{Name: "force", Short: 'f'},
+					{Short: 'b', TakesValue: true, Complete: branches},
+				},
+				Args: []*Arg{{Name: "branch", Complete: branches}, {Name: "paths", Optional: true, Variadic: true}},
+			},
+			{
+				Name: "log",
+				Flags: []*Flag{
+					{Name: "format", TakesValue: true, Choices: []string{"oneline", "full"}},
+					{Name: "author", TakesValue: true, Repeatable: true},
+					{Name: "max-count", Short: 'n', TakesValue: true, Type: ArgInt},
+				},
+			},
+			{Name: "remote", Subcommands: []*Command{{Name: "add"}, {Name: "remove"}}},
+		},
+	}
+}
+
+func TestCommandParse(t *testing.T) {
+	command := newTestCommand(new([]ArgContext))
+	testCases := []struct {
+		line     string
+		expected *ParsedCommand
+		err      string
+	}{
+		{"co -fv main a 'b c'", &ParsedCommand{
+			Path:  []string{"checkout"},
+			Flags: map[string][]string{"force": {"true"}, "verbose": {"true"}},
+			Args:  []string{"main", "a", "b c"},
+		}, ""},
+		{"-v checkout -b new -- -x", &ParsedCommand{
+			Path:  []string{"checkout"},
+			Flags: map[string][]string{"verbose": {"true"}, "b": {"new"}},
+			Args:  []string{"-x"},
+		}, ""},
+		{"log --format=full --author a --author=b -n5", &ParsedCommand{
+			Path:  []string{"log"},
+			Flags: map[string][]string{"format": {"full"}, "author": {"a", "b"}, "max-count": {"5"}},
+		}, ""},
+		{"remote add", &ParsedCommand{Path: []string{"remote", "add"}, Flags: map[string][]string{}}, ""},
+		{"checkout", nil, "missing argument branch"},
+		{"checkout -f -f main", nil, "flag --force given more than once"},
+		{"log --format=short", nil, `invalid value "short" for --format: expected one of oneline, full`},
+		{"log -n x", nil, `invalid value "x" for --max-count: expected an integer`},
+		{"log --format", nil, "flag --format requires a value"},
+		{"log --verbose=1", nil, "flag --verbose does not take a value"},
+		{"log --bogus", nil, "unknown flag --bogus"},
+		{"log x", nil, "too many arguments"},
+		{"push", nil, `unknown command "push"`},
+	}
+	for _, tc := range testCases {
+		parsed, err := command.Parse(tc.line)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("parsing %q: expected error %q, got %v", tc.line, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsing %q: %v", tc.line, err)
+			continue
+		}
+		parsed.Command = nil
+		if !reflect.DeepEqual(parsed, tc.expected) {
+			t.Errorf("parsing %q: expected %+v, got %+v", tc.line, tc.expected, parsed)
+		}
+	}
+
+	parsed, err := command.Parse("log --author a --author b -v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Command.Name != "log" || !parsed.Bool("verbose") || parsed.Bool("format") ||
+		parsed.Value("author") != "b" || !reflect.DeepEqual(parsed.Values("author"), []string{"a", "b"}) {
+		t.Errorf("unexpected result: %+v", parsed)
+	}
+}
+
+func TestCommandComplete(t *testing.T) {
+	var contexts []ArgContext
+	command := newTestCommand(&contexts)
+	testCases := []struct {
+		line     string
+		expected []string
+	}{
+		{"", []string{"checkout", "log", "remote"}},
+		{"c", []string{"checkout"}},
+		{"co", []string{"co"}},
+		{"remote r", []string{"remove"}},
+		{"-", []string{"--verbose"}},
+		{"checkout -", []string{"--force", "-b", "--verbose"}},
+		{"checkout -f --", []string{"--verbose"}},
+		{"log --", []string{"--format", "--author", "--max-count", "--verbose"}},
+		{"log --format ", []string{"oneline", "full"}},
+		{"log --format=o", []string{"--format=oneline"}},
+		{"log --format='f", []string{"--format=full"}},
+		{"checkout ", []string{"main", `my\ branch`}},
+		{`checkout "my`, []string{`"my branch"`}},
+		{"checkout -b m", []string{"main", `my\ branch`}},
+		{"checkout main ", nil},
+		{"checkout -- -", nil},
+	}
+	for _, tc := range testCases {
+		line := []rune(tc.line)
+		completions := command.DoRich(line, len(line))
+		var texts []string
+		for _, c := range completions.Candidates {
+			texts = append(texts, c.Text)
+		}
+		if !reflect.DeepEqual(texts, tc.expected) {
+			t.Errorf("completing %q: expected %q, got %q", tc.line, tc.expected, texts)
+		}
+		if start, _ := wordStart(line, len(line)); completions.Offset != len(line)-start {
+			t.Errorf("completing %q: unexpected offset %d", tc.line, completions.Offset)
+		}
+	}
+
+	// the completers receive the context of the word:
+	contexts = nil
+	command.DoRich([]rune("-v co -f -b x"), 13)
+	command.DoRich([]rune("co -f ma"), 8)
+	if len(contexts) != 2 {
+		t.Fatalf("unexpected calls: %+v", contexts)
+	}
+	if c := contexts[0]; c.ArgIndex != -1 || c.Flag == nil || c.Flag.Short != 'b' || c.Word != "x" || !c.Bool("verbose") {
+		t.Errorf("unexpected context: %+v", c)
+	}
+	if c := contexts[1]; c.ArgIndex != 0 || c.Position != 0 || c.Flag != nil || c.Word != "ma" || !reflect.DeepEqual(c.Path, []string{"checkout"}) || !c.Bool("force") {
+		t.Errorf("unexpected context: %+v", c)
+	}
+
+	// all the values of a variadic argument have its index:
+	contexts = nil
+	record := func(ctx *ArgContext) []Candidate {
+		contexts = append(contexts, *ctx)
+		return nil
+	}
+	cp := &Command{Args: []*Arg{{Name: "source", Complete: record}, {Name: "dest", Variadic: true, Complete: record}}}
+	cp.DoRich([]rune("a b c "), 6)
+	if len(contexts) != 1 || contexts[0].ArgIndex != 1 || contexts[0].Position != 3 {
+		t.Errorf("unexpected contexts: %+v", contexts)
+	}
+}
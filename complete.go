@@ -4,7 +4,10 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"sort"
+	"strings"
 	"sync/atomic"
+	"unicode"
 
 	"github.com/ergochat/readline/internal/platform"
 	"github.com/ergochat/readline/internal/runes"
@@ -21,6 +24,190 @@ type AutoCompleter interface {
 	Do(line []rune, pos int) (newLine [][]rune, length int)
 }
 
+// Candidate is a completion candidate returned by a RichCompleter.
+type Candidate struct {
+	// Text replaces the word being completed (see Completions.Offset).
+	Text string
+	// Display is shown in the list of candidates instead of Text, if set.
+	Display string
+	// Description is shown next to the candidate, in an aligned column.
+	Description string
+	// Group is the category of the candidate: candidates are listed under
+	// a header for each group, in the order in which the groups appear.
+	Group string
+	// Suffix is inserted after Text when the candidate is selected, but not
+	// when only a prefix common to several candidates is inserted; e.g. a
+	// space, or "/" after a directory name.
+	Suffix string
+	// Style is an ANSI SGR escape sequence (e.g. "\033[34m" for blue) with
+	// which the candidate is displayed in the list, unless it is selected.
+	Style string
+}
+
+func (c *Candidate) display() string {
+	if c.Display != "" {
+		return c.Display
+	}
+	return c.Text
+}
+
+// CandidateRenderer is a callback type to customize how completion
+// candidates are displayed in the list (see Config.CandidateRenderer). It
+// returns the text displayed for c, which can contain ANSI SGR escape
+// sequences: they are ignored when computing the width of the columns. The
+// attributes in effect at the end of the text extend over the padding that
+// follows it in its column, and are then reset. selected is whether c is the
+// candidate currently selected in the list.
+type CandidateRenderer func(c *Candidate, selected bool) string
+
+// DefaultCandidateRenderer is the CandidateRenderer used if none is set: it
+// displays the candidate with its Style, or in black on white if it is
+// selected.
+func DefaultCandidateRenderer(c *Candidate, selected bool) string {
+	if selected {
+		return "\033[30;47m" + c.display()
+	} else if c.Style != "" {
+		return c.Style + c.display()
+	}
+	return c.display()
+}
+
+// Completions is the result of RichCompleter.DoRich.
+type Completions struct {
+	Candidates []Candidate
+	// Offset is the number of runes before the cursor (the word being
+	// completed) that are replaced by the candidate that is inserted.
+	Offset int
+	// After is the number of runes after the cursor that are replaced as
+	// well, when the cursor is in the middle of the word being completed;
+	// the cursor is then placed after the inserted candidate. It can not be
+	// expressed by AutoCompleter.Do, so it is ignored by the adapters.
+	After int
+	// Filterable declares that the candidates are all those for any word
+	// that starts with the word being completed, and that their Text starts
+	// with the word. While the candidates are listed, the completer is then
+	// not called again as more runes are typed: the candidates are filtered
+	// instead, until the start of the word changes (e.g. a space is typed).
+	Filterable bool
+}
+
+// completionCache is the result of a completion marked as Filterable.
+type completionCache struct {
+	line       []rune
+	pos        int
+	offset     int
+	after      int
+	candidates []Candidate
+}
+
+// CompletionStyle selects how the candidates for completion are presented
+// (see Config.CompletionStyle).
+type CompletionStyle int
+
+const (
+	// CompletionList is the default style: Tab inserts the longest prefix
+	// common to the candidates, a second Tab lists them, and a third Tab
+	// starts selecting one of them in the list.
+	CompletionList CompletionStyle = iota
+	// CompletionMenu is the style of GNU Readline's menu-complete: each Tab
+	// replaces the word being completed with the next candidate, and after
+	// the last candidate restores the original word; Shift-Tab cycles in the
+	// opposite direction. No list is displayed, and the AutoCompleter is
+	// called synchronously, even if it implements ContextCompleter.
+	CompletionMenu
+	// CompletionMenuSelect is the style of zsh's menu selection: Tab lists
+	// the candidates and selects the first one, and the selected candidate
+	// is previewed in the line as the selection moves. Enter or any other
+	// key accepts it, and Ctrl-G restores the original word.
+	CompletionMenuSelect
+)
+
+// RichCompleter is an optional interface that can be implemented by the
+// AutoCompleter in Config.AutoComplete, to return candidates with display
+// text, descriptions, groups and suffixes. If it is implemented, DoRich is
+// called instead of Do.
+type RichCompleter interface {
+	// Readline will pass the whole line and current offset to it.
+	DoRich(line []rune, pos int) Completions
+}
+
+// CandidateDescriber is an optional interface that can be implemented by the
+// AutoCompleter in Config.AutoComplete. If it is implemented, and
+// Config.DescribeCandidate is nil, Describe is used in its place.
+type CandidateDescriber interface {
+	Describe(c Candidate) string
+}
+
+// maxDetailLines is the maximum number of lines reserved below the list of
+// candidates for the description of the selected candidate.
+const maxDetailLines = 5
+
+// RichCompleterFunc adapts a function to the RichCompleter interface. It
+// also implements AutoCompleter, so it can be used in Config.AutoComplete.
+type RichCompleterFunc func(line []rune, pos int) Completions
+
+var (
+	_ AutoCompleter = RichCompleterFunc(nil)
+	_ RichCompleter = RichCompleterFunc(nil)
+)
+
+func (f RichCompleterFunc) DoRich(line []rune, pos int) Completions {
+	return f(line, pos)
+}
+
+// Do returns the candidates that start with the word being completed, in
+// the format of AutoCompleter.
+func (f RichCompleterFunc) Do(line []rune, pos int) (newLine [][]rune, length int) {
+	return completionsToSuffixes(line, pos, f(line, pos))
+}
+
+// completionsToSuffixes converts the result of RichCompleter.DoRich to
+// that of AutoCompleter.Do, keeping the candidates that start with the word
+// being completed.
+func completionsToSuffixes(line []rune, pos int, completions Completions) (newLine [][]rune, length int) {
+	length = clampOffset(completions.Offset, pos)
+	word := line[pos-length : pos]
+	for _, c := range completions.Candidates {
+		text := []rune(c.Text + c.Suffix)
+		if runes.HasPrefix(text, word) {
+			newLine = append(newLine, text[len(word):])
+		}
+	}
+	return
+}
+
+// suffixesToCompletions converts the result of AutoCompleter.Do to that of
+// RichCompleter.DoRich: the candidates include the runes they have in
+// common with the line.
+func suffixesToCompletions(line []rune, pos int, newLine [][]rune, length int) (completions Completions) {
+	completions.Offset = clampOffset(length, pos)
+	same := string(line[pos-completions.Offset : pos])
+	completions.Candidates = make([]Candidate, len(newLine))
+	for i := range newLine {
+		completions.Candidates[i].Text = same + string(newLine[i])
+	}
+	return
+}
+
+// clampAfter clamps Completions.After to the runes of line after pos.
+func clampAfter(after, pos int, line []rune) int {
+	if after < 0 {
+		return 0
+	} else if after > len(line)-pos {
+		return len(line) - pos
+	}
+	return after
+}
+
+func clampOffset(offset, pos int) int {
+	if offset < 0 {
+		return 0
+	} else if offset > pos {
+		return pos
+	}
+	return offset
+}
+
 type opCompleter struct {
 	w  *terminal
 	op *operation
@@ -28,15 +215,37 @@ type opCompleter struct {
 	inCompleteMode atomic.Uint32 // this is read asynchronously from wrapWriter
 	inSelectMode   bool
 
-	candidate         [][]rune // list of candidates
-	candidateSource   []rune   // buffer string when tab was pressed
-	candidateOff      int      // num runes in common from buf where candidate start
-	candidateChoice   int      // absolute index of the chosen candidate (indexing the candidate array which might not all display in current page)
-	candidateColNum   int      // num columns candidates take 0..wraps, 1 col, 2 cols etc.
-	candidateColWidth int      // width of candidate columns
-	linesAvail        int      // number of lines available below the user's prompt which could be used for rendering the completion
-	pageStartIdx      []int    // start index in the candidate array on each page (candidatePageStart[i] = absolute idx of the first candidate on page i)
-	curPage           int      // index of the current page
+	candidate         []Candidate // list of candidates
+	candidateRich     bool        // whether candidates have descriptions or groups, which are displayed in a single column
+	candidateSource   []rune      // buffer string when tab was pressed
+	candidateOff      int         // num runes before the cursor replaced by the candidates
+	candidateAfter    int         // num runes after the cursor replaced by the candidates, until one is inserted
+	candidateChoice   int         // absolute index of the chosen candidate (indexing the candidate array which might not all display in current page)
+	candidateColNum   int         // num columns candidates take 0..wraps, 1 col, 2 cols etc.
+	candidateColWidth int         // width of candidate columns
+	linesAvail        int         // number of lines available below the user's prompt which could be used for rendering the completion
+	detailLines       int         // number of lines reserved below the candidates for the description of the selected one
+	pageStartIdx      []int       // start index in the candidate array on each page (candidatePageStart[i] = absolute idx of the first candidate on page i)
+	curPage           int         // index of the current page
+
+	pending *completionRequest // asynchronous completion in progress, if any
+	loading bool               // whether the loading indicator of pending is displayed
+
+	candidateWord []rune // with CompletionMenuSelect, the word replaced by the previewed candidates
+
+	candidateAll    []Candidate // with CompletionSelectFilter, all the candidates, of which candidate are those matching candidateFilter
+	candidateFilter []rune      // with CompletionSelectFilter, the text typed in select mode
+
+	menuCandidates []Candidate // with CompletionMenu, the candidates being cycled through
+	menuChoice     int         // index of the inserted candidate in menuCandidates, or -1 for menuWord
+	menuWord       []rune      // the word replaced by menuCandidates
+	menuLen        int         // num runes before the cursor inserted by the last menu completion
+
+	cache *completionCache // the last completion, if it is Filterable
+
+	inQueryMode bool     // with CompletionQueryItems, whether the user is asked whether to display the candidates
+	inPagerMode bool     // whether the pager is displaying the candidates
+	pagerLines  []string // lines of the list of candidates not yet displayed by the pager
 }
 
 func newOpCompleter(w *terminal, op *operation) *opCompleter {
@@ -48,11 +257,12 @@ func newOpCompleter(w *terminal, op *operation) *opCompleter {
 
 func (o *opCompleter) doSelect() {
 	if len(o.candidate) == 1 {
-		o.op.buf.WriteRunes(o.candidate[0])
+		o.insertCandidate(&o.candidate[0], o.candidateOff)
 		o.ExitCompleteMode(false)
 		return
 	}
 	o.nextCandidate()
+	o.previewCandidate()
 	o.CompleteRefresh()
 }
 
@@ -84,6 +294,44 @@ func (o *opCompleter) nextCandidate() {
 	}
 }
 
+// Move selection to the previous candidate, updating page if necessary; from
+// the first candidate, it wraps around only if the page of the last
+// candidate is known
+func (o *opCompleter) prevCandidate() {
+	if o.candidateChoice > 0 {
+		o.candidateChoice -= 1
+		if o.candidateChoice < o.pageStartIdx[o.curPage] {
+			o.curPage -= 1
+		}
+		return
+	}
+	last := len(o.candidate) - 1
+	if o.pageStartIdx[len(o.pageStartIdx)-1] <= last {
+		return
+	}
+	o.candidateChoice = last
+	o.curPage = len(o.pageStartIdx) - 1
+	for o.pageStartIdx[o.curPage] > last {
+		o.curPage -= 1
+	}
+}
+
+// previewCandidate replaces the word being completed with the chosen
+// candidate, in the CompletionMenuSelect style.
+func (o *opCompleter) previewCandidate() {
+	if o.op.GetConfig().CompletionStyle != CompletionMenuSelect || o.candidateChoice < 0 {
+		return
+	}
+	pos := o.op.buf.Pos()
+	if o.candidateWord == nil {
+		o.candidateWord = runes.Copy(o.op.buf.Runes()[pos-o.candidateOff : pos+o.candidateAfter])
+	}
+	text := []rune(o.candidate[o.candidateChoice].Text)
+	o.op.buf.ReplaceRange(pos-o.candidateOff, pos+o.candidateAfter, text)
+	o.candidateAfter = 0
+	o.candidateOff = len(text)
+}
+
 // Move selection to the next ith col in the current line, wrapping to the line start/end if needed
 func (o *opCompleter) nextCol(i int) {
 	// If o.candidateColNum == 1 or 0, there is only one col per line and this is a noop
@@ -201,21 +449,34 @@ func (o *opCompleter) OnComplete() (ringBell bool) {
 
 	// If in complete mode and nothing else typed then we must be entering select mode
 	if o.IsInCompleteMode() && o.candidateSource != nil && runes.Equal(rs, o.candidateSource) {
-		if len(o.candidate) > 1 {
-			same, size := runes.Aggregate(o.candidate)
-			if size > 0 {
-				buf.WriteRunes(same)
-				o.ExitCompleteMode(false)
-				return false // partial completion so ring the bell
-			}
+		if len(o.candidate) > 1 && o.insertCommonPrefix(o.candidate, o.candidateOff) {
+			o.ExitCompleteMode(false)
+			return false // partial completion so ring the bell
 		}
 		o.EnterCompleteSelectMode()
 		o.doSelect()
 		return true
 	}
 
-	newLines, offset := o.op.GetConfig().AutoComplete.Do(rs, buf.idx)
-	if len(newLines) == 0 || (len(newLines) == 1 && len(newLines[0]) == 0) {
+	pos := buf.Pos()
+	if o.IsInCompleteMode() {
+		if candidates, offset, ok := o.filterCache(rs, pos); ok {
+			return o.showCandidates(rs, pos, candidates, offset)
+		}
+	}
+	if completer, ok := o.op.GetConfig().AutoComplete.(ContextCompleter); ok {
+		// the candidates are displayed when they arrive, see finishRequest
+		o.startRequest(completer, rs, pos)
+		return true
+	}
+	candidates, offset := o.complete(rs, pos)
+	return o.showCandidates(rs, pos, candidates, offset)
+}
+
+// showCandidates inserts or lists the candidates for completing rs; it
+// returns false if the bell should be rung.
+func (o *opCompleter) showCandidates(rs []rune, pos int, candidates []Candidate, offset int) bool {
+	if len(candidates) == 0 || (len(candidates) == 1 && candidates[0].Text+candidates[0].Suffix == string(rs[pos-offset:pos+o.candidateAfter])) {
 		o.ExitCompleteMode(false)
 		return false // will ring bell on initial tab press
 	}
@@ -228,29 +489,187 @@ func (o *opCompleter) OnComplete() (ringBell bool) {
 	}
 	o.candidateSource = rs
 
+	if o.op.GetConfig().CompletionStyle == CompletionMenuSelect && !o.IsInCompleteMode() {
+		if len(candidates) == 1 {
+			o.insertCandidate(&candidates[0], offset)
+			o.ExitCompleteMode(false)
+			return true
+		}
+		o.EnterCompleteMode(offset, candidates)
+		o.EnterCompleteSelectMode()
+		o.doSelect()
+		return true
+	}
+
 	// only Aggregate candidates in non-complete mode
 	if !o.IsInCompleteMode() {
-		if len(newLines) == 1 {
+		if len(candidates) == 1 {
 			// not yet in complete mode but only 1 candidate so complete it
-			buf.WriteRunes(newLines[0])
+			o.insertCandidate(&candidates[0], offset)
 			o.ExitCompleteMode(false)
 			return true
 		}
 
-		// check if all candidates have common prefix and return it and its size
-		same, size := runes.Aggregate(newLines)
-		if size > 0 {
-			buf.WriteRunes(same)
+		// check if all candidates have common prefix and insert it
+		if o.insertCommonPrefix(candidates, offset) {
 			o.ExitCompleteMode(false)
 			return false // partial completion so ring the bell
 		}
 	}
 
-	// otherwise, we just enter complete mode (which does a refresh)
-	o.EnterCompleteMode(offset, newLines)
+	// otherwise, we just enter complete mode (which does a refresh), but
+	// first ask whether to display that many candidates
+	if n := o.op.GetConfig().CompletionQueryItems; n > 0 && len(candidates) >= n && !o.IsInCompleteMode() {
+		o.inQueryMode = true
+	}
+	o.EnterCompleteMode(offset, candidates)
 	return true
 }
 
+// complete returns the candidates for completing line, and the number of
+// runes before pos that they replace; it sets candidateAfter to the number
+// of runes after pos that they replace.
+func (o *opCompleter) complete(line []rune, pos int) (candidates []Candidate, offset int) {
+	var completions Completions
+	completer := o.op.GetConfig().AutoComplete
+	switch c := completer.(type) {
+	case RichCompleter:
+		completions = c.DoRich(line, pos)
+	case *PrefixCompleter:
+		completions = c.doRich(line, pos)
+	default:
+		newLine, length := completer.Do(line, pos)
+		completions = suffixesToCompletions(line, pos, newLine, length)
+		// a type embedding a PrefixCompleter completes words like it does,
+		// so the rest of the word under the cursor is replaced as well
+		if _, ok := completer.(interface{ prefixCompleter() *PrefixCompleter }); ok {
+			completions.After = wordEnd(line, pos) - pos
+		}
+	}
+	o.candidateAfter = clampAfter(completions.After, pos, line)
+	o.cacheCompletions(line, pos, completions)
+	return completions.Candidates, clampOffset(completions.Offset, pos)
+}
+
+// cacheCompletions records the result of completing line, if it is
+// Filterable, for filterCache.
+func (o *opCompleter) cacheCompletions(line []rune, pos int, completions Completions) {
+	o.cache = nil
+	if completions.Filterable {
+		o.cache = &completionCache{
+			line:       line,
+			pos:        pos,
+			offset:     clampOffset(completions.Offset, pos),
+			after:      clampAfter(completions.After, pos, line),
+			candidates: completions.Candidates,
+		}
+	}
+}
+
+// filterCache returns the cached candidates that match the word being
+// completed in line, if line only differs from the cached one by runes
+// typed at the end of the word; otherwise ok is false. Like complete, it
+// sets candidateAfter.
+func (o *opCompleter) filterCache(line []rune, pos int) (candidates []Candidate, offset int, ok bool) {
+	c := o.cache
+	if c == nil || pos < c.pos || !runes.Equal(line[:c.pos], c.line[:c.pos]) || !runes.Equal(line[pos:], c.line[c.pos:]) {
+		return nil, 0, false
+	}
+	for _, r := range line[c.pos:pos] {
+		if unicode.IsSpace(r) {
+			return nil, 0, false // the word may end here
+		}
+	}
+	start := c.pos - c.offset
+	word := string(line[start:pos])
+	for _, candidate := range c.candidates {
+		if strings.HasPrefix(candidate.Text, word) {
+			candidates = append(candidates, candidate)
+		}
+	}
+	o.candidateAfter = c.after
+	return candidates, pos - start, true
+}
+
+// insertCandidate replaces the word being completed (the offset runes
+// before the cursor, and the candidateAfter runes after it) with a
+// candidate.
+func (o *opCompleter) insertCandidate(c *Candidate, offset int) {
+	pos := o.op.buf.Pos()
+	o.op.buf.ReplaceRange(pos-offset, pos+o.candidateAfter, []rune(c.Text+c.Suffix))
+	o.candidateAfter = 0
+}
+
+// insertCommonPrefix replaces the word being completed with the longest
+// common prefix of the candidates, if it is longer than the word; it
+// returns whether it did. It does not when the cursor is in the middle of
+// the word, since that would drop the runes after the cursor.
+func (o *opCompleter) insertCommonPrefix(candidates []Candidate, offset int) bool {
+	if o.candidateAfter != 0 {
+		return false
+	}
+	prefix := []rune(candidates[0].Text)
+	for _, c := range candidates[1:] {
+		text := []rune(c.Text)
+		n := 0
+		for n < len(prefix) && n < len(text) && prefix[n] == text[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	pos := o.op.buf.Pos()
+	// with MatchSubstring or MatchFuzzy, the candidates may not start with
+	// the word (ignoring case, as with MatchPrefixFold)
+	if len(prefix) <= offset || !runes.HasPrefixFold(prefix, o.op.buf.Runes()[pos-offset:pos]) {
+		return false
+	}
+	o.op.buf.ReplaceRange(pos-offset, pos, prefix)
+	return true
+}
+
+// menuComplete replaces the word being completed with the next candidate
+// (or the previous one if dir is -1), in the CompletionMenu style; repeat is
+// whether the previous command was also menuComplete. It returns false if
+// the bell should be rung.
+func (o *opCompleter) menuComplete(dir int, repeat bool) bool {
+	buf := o.op.buf
+	if !repeat {
+		rs, pos := buf.Runes(), buf.Pos()
+		candidates, offset := o.complete(rs, pos)
+		o.menuCandidates = nil
+		if len(candidates) == 0 {
+			return false
+		} else if len(candidates) == 1 {
+			o.insertCandidate(&candidates[0], offset)
+			return true
+		}
+		o.menuCandidates = candidates
+		o.menuChoice = -1
+		o.menuWord = runes.Copy(rs[pos-offset : pos+o.candidateAfter])
+		o.menuLen = offset
+	}
+	if len(o.menuCandidates) == 0 {
+		return false
+	}
+
+	// cycle through the candidates, then the original word
+	o.menuChoice += dir
+	if o.menuChoice == len(o.menuCandidates) {
+		o.menuChoice = -1
+	} else if o.menuChoice < -1 {
+		o.menuChoice = len(o.menuCandidates) - 1
+	}
+	text := o.menuWord
+	if o.menuChoice != -1 {
+		text = []rune(o.menuCandidates[o.menuChoice].Text)
+	}
+	pos := buf.Pos()
+	buf.ReplaceRange(pos-o.menuLen, pos+o.candidateAfter, text)
+	o.candidateAfter = 0
+	o.menuLen = len(text)
+	return o.menuChoice != -1
+}
+
 func (o *opCompleter) IsInCompleteSelectMode() bool {
 	return o.inSelectMode
 }
@@ -261,16 +680,30 @@ func (o *opCompleter) IsInCompleteMode() bool {
 
 func (o *opCompleter) HandleCompleteSelect(r rune) (stayInMode bool) {
 	next := true
+	filtering := o.op.GetConfig().CompletionSelectFilter
+	if filtering && r > 0 && unicode.IsPrint(r) {
+		filter := append(runes.Copy(o.candidateFilter), r)
+		if !o.filterCandidates(filter) {
+			o.w.Bell()
+		}
+		o.previewCandidate()
+		o.CompleteRefresh()
+		return true
+	}
 	switch r {
 	case CharEnter, CharCtrlJ:
 		next = false
-		o.op.buf.WriteRunes(o.candidate[o.candidateChoice])
+		o.insertCandidate(&o.candidate[o.candidateChoice], o.candidateOff)
 		o.ExitCompleteMode(false)
 	case CharLineStart:
 		o.lineStart()
 	case CharLineEnd:
 		o.lineEnd()
 	case CharBackspace:
+		if filtering && len(o.candidateFilter) != 0 {
+			o.filterCandidates(o.candidateFilter[:len(o.candidateFilter)-1])
+			break
+		}
 		o.ExitCompleteSelectMode()
 		next = false
 	case CharTab:
@@ -282,10 +715,20 @@ func (o *opCompleter) HandleCompleteSelect(r rune) (stayInMode bool) {
 		next = false
 	case CharNext:
 		o.nextLine()
-	case CharBackward, MetaShiftTab:
+	case MetaShiftTab:
+		if o.op.GetConfig().CompletionStyle == CompletionMenuSelect {
+			o.prevCandidate()
+		} else {
+			o.nextCol(-1)
+		}
+	case CharBackward:
 		o.nextCol(-1)
 	case CharPrev:
 		o.prevLine()
+	case MetaPageUp:
+		o.prevPage()
+	case MetaPageDown:
+		o.nextPage()
 	case 'j', 'J':
 		o.prevPage()
 	case 'k', 'K':
@@ -295,12 +738,39 @@ func (o *opCompleter) HandleCompleteSelect(r rune) (stayInMode bool) {
 		o.ExitCompleteSelectMode()
 	}
 	if next {
+		o.previewCandidate()
 		o.CompleteRefresh()
 		return true
 	}
 	return false
 }
 
+// filterCandidates narrows the list to the candidates whose display text
+// contains filter, ignoring case, and selects the first one; if there is no
+// such candidate, it returns false and leaves the list unchanged.
+func (o *opCompleter) filterCandidates(filter []rune) bool {
+	all := o.candidateAll
+	if all == nil {
+		all = o.candidate
+	}
+	var filtered []Candidate
+	for _, c := range all {
+		if len(filter) == 0 || runes.IndexAllEx([]rune(c.display()), filter, true) != -1 {
+			filtered = append(filtered, c)
+		}
+	}
+	if len(filtered) == 0 {
+		return false
+	}
+	o.candidateAll = all
+	o.candidate = filtered
+	o.candidateFilter = filter
+	o.setColumnInfo()
+	o.initPage()
+	o.candidateChoice = 0
+	return true
+}
+
 func (o *opCompleter) getMatrixSize() int {
 	colNum := 1
 	if o.candidateColNum > 1 {
@@ -337,22 +807,31 @@ func (o *opCompleter) getMatrixNumRows() int {
 // setColumnInfo calculates column width and number of columns required
 // to present the list of candidates on the terminal.
 func (o *opCompleter) setColumnInfo() {
-	same := o.op.buf.RuneSlice(-o.candidateOff)
-	sameWidth := runes.WidthAll(same)
-
 	colWidth := 0
-	for _, c := range o.candidate {
-		w := sameWidth + runes.WidthAll(c)
-		if w > colWidth {
-			colWidth = w
+	for i := range o.candidate {
+		for _, selected := range []bool{false, true} {
+			if _, w := o.renderCandidate(&o.candidate[i], selected); w > colWidth {
+				colWidth = w
+			}
 		}
 	}
-	colWidth++ // whitespace between cols
 
 	tWidth, _ := o.w.GetWidthHeight()
-
 	// -1 to avoid end of line issues
 	width := tWidth - 1
+
+	if o.candidateRich {
+		// one candidate per line, followed by its description; leave at
+		// least half of the line for descriptions
+		if colWidth > width/2 {
+			colWidth = width / 2
+		}
+		o.candidateColNum = 1
+		o.candidateColWidth = colWidth
+		return
+	}
+
+	colWidth++ // whitespace between cols
 	colNum := width / colWidth
 	if colNum != 0 {
 		colWidth += (width - (colWidth * colNum)) / colNum
@@ -362,11 +841,73 @@ func (o *opCompleter) setColumnInfo() {
 	o.candidateColWidth = colWidth
 }
 
+// renderCandidate returns the text displayed for c in the list of
+// candidates, and its width on the screen.
+func (o *opCompleter) renderCandidate(c *Candidate, selected bool) (text string, width int) {
+	text = o.op.GetConfig().CandidateRenderer(c, selected)
+	return text, runes.WidthAll(runes.ColorFilter([]rune(text)))
+}
+
+// formatCandidate returns the text of c in the list of candidates, padded
+// to the width of its column, and its width on the screen.
+func (o *opCompleter) formatCandidate(c *Candidate, selected bool) (text string, width int) {
+	tWidth, _ := o.w.GetWidthHeight()
+	display, cWidth := o.renderCandidate(c, selected)
+	var description []rune
+	if o.candidateRich && c.Description != "" {
+		descWidth := tWidth - 1 - o.candidateColWidth - 2
+		if cWidth > o.candidateColWidth {
+			descWidth -= cWidth - o.candidateColWidth
+		}
+		description = truncateWidth([]rune(c.Description), descWidth)
+	}
+	width = cWidth
+	if len(description) != 0 {
+		width = o.candidateColWidth + 2 + runes.WidthAll(description)
+		if cWidth > o.candidateColWidth {
+			width += cWidth - o.candidateColWidth
+		}
+	}
+
+	var buf strings.Builder
+	buf.WriteString(display)
+	if o.candidateColNum >= 1 && cWidth < o.candidateColWidth && (!o.candidateRich || len(description) != 0) {
+		// only output spaces between columns if everything fits
+		buf.WriteString(strings.Repeat(" ", o.candidateColWidth-cWidth))
+	}
+	if strings.IndexByte(display, '\033') != -1 {
+		buf.WriteString("\033[0m")
+	}
+	if len(description) != 0 {
+		buf.WriteString("  ")
+		buf.WriteString(string(description))
+	}
+	return buf.String(), width
+}
+
+// truncateWidth returns the longest prefix of rs that fits in width.
+func truncateWidth(rs []rune, width int) []rune {
+	for i, r := range rs {
+		width -= runes.Width(r)
+		if width < 0 {
+			return rs[:i]
+		}
+	}
+	return rs
+}
+
 // CompleteRefresh is used for completemode and selectmode
 func (o *opCompleter) CompleteRefresh() {
 	if !o.IsInCompleteMode() {
 		return
 	}
+	if o.inQueryMode {
+		o.messageRefresh(fmt.Sprintf("Display all %d possibilities? (y or n)", len(o.candidate)))
+		return
+	} else if o.inPagerMode {
+		o.messageRefresh("--More--")
+		return
+	}
 
 	buf := bufio.NewWriter(o.w)
 	// calculate num lines from cursor pos to where choices should be written
@@ -374,36 +915,49 @@ func (o *opCompleter) CompleteRefresh() {
 	buf.Write(bytes.Repeat([]byte("\n"), lineCnt)) // move down from cursor to start of candidates
 	buf.WriteString("\033[J")
 
-	same := o.op.buf.RuneSlice(-o.candidateOff)
 	tWidth, _ := o.w.GetWidthHeight()
 
 	colIdx := 0
 	lines := 0
-	sameWidth := runes.WidthAll(same)
 
 	// Show completions for the current page
-	idx := o.pageStartIdx[o.curPage]
+	pageStart := o.pageStartIdx[o.curPage]
+	idx := pageStart
 	for ; idx < len(o.candidate); idx++ {
+		c := &o.candidate[idx]
+		// in the single-column layout, a header precedes the first
+		// candidate of each group (and the first candidate of the page)
+		header := o.candidateRich && c.Group != "" && (idx == pageStart || c.Group != o.candidate[idx-1].Group)
+
 		// If writing the current candidate would overflow the page,
 		// we know that it is the start of the next page.
-		if colIdx == 0 && lines == o.linesAvail {
+		if colIdx == 0 && (lines == o.linesAvail || (header && lines+1 >= o.linesAvail)) {
 			if o.curPage == len(o.pageStartIdx)-1 {
 				o.pageStartIdx = append(o.pageStartIdx, idx)
 			}
 			break
 		}
 
-		c := o.candidate[idx]
+		if header {
+			if lines > 0 {
+				buf.WriteString("\n")
+			}
+			buf.WriteString("\033[1m")
+			buf.WriteString(string(truncateWidth([]rune(c.Group), tWidth-1)))
+			buf.WriteString("\033[0m")
+			lines++
+		}
+
 		inSelect := idx == o.candidateChoice && o.IsInCompleteSelectMode()
-		cWidth := sameWidth + runes.WidthAll(c)
+		text, lineWidth := o.formatCandidate(c, inSelect)
 		cLines := 1
 		if tWidth > 0 {
 			sWidth := 0
 			if platform.IsWindows && inSelect {
 				sWidth = 1 // adjust for hightlighting on Windows
 			}
-			cLines = (cWidth + sWidth) / tWidth
-			if (cWidth+sWidth)%tWidth > 0 {
+			cLines = (lineWidth + sWidth) / tWidth
+			if (lineWidth+sWidth)%tWidth > 0 {
 				cLines++
 			}
 		}
@@ -416,20 +970,7 @@ func (o *opCompleter) CompleteRefresh() {
 			buf.WriteString("\n")
 		}
 
-		if inSelect {
-			buf.WriteString("\033[30;47m")
-		}
-
-		buf.WriteString(string(same))
-		buf.WriteString(string(c))
-		if o.candidateColNum >= 1 {
-			// only output spaces between columns if everything fits
-			buf.Write(bytes.Repeat([]byte(" "), o.candidateColWidth-cWidth))
-		}
-
-		if inSelect {
-			buf.WriteString("\033[0m")
-		}
+		buf.WriteString(text)
 
 		colIdx++
 		if colIdx >= o.candidateColNum {
@@ -451,12 +992,35 @@ func (o *opCompleter) CompleteRefresh() {
 		lines++ // mid-line so count it.
 	}
 
-	// Show the guidance if there are more pages
-	if idx != len(o.candidate) || o.curPage > 0 {
-		buf.WriteString("\n-- (j: prev page) (k: next page) --")
+	// Show the filter, and the guidance if there are more pages
+	morePages := idx != len(o.candidate) || o.curPage > 0
+	if morePages || len(o.candidateFilter) != 0 {
+		buf.WriteString("\n-- ")
+		if len(o.candidateFilter) != 0 {
+			fmt.Fprintf(buf, "filter: %s ", string(o.candidateFilter))
+		}
+		if morePages && o.op.GetConfig().CompletionSelectFilter {
+			buf.WriteString("(PgUp: prev page) (PgDn: next page) ")
+		} else if morePages {
+			buf.WriteString("(j: prev page) (k: next page) ")
+		}
+		buf.WriteString("--")
 		lines++
 	}
 
+	// Show the description of the selected candidate
+	if describe := o.describer(); describe != nil && o.detailLines > 0 && o.IsInCompleteSelectMode() && o.candidateChoice >= 0 {
+		var detail []string
+		if text := strings.TrimRight(describe(o.candidate[o.candidateChoice]), "\n"); text != "" {
+			detail = strings.Split(text, "\n")
+		}
+		for i := 0; i < len(detail) && i < o.detailLines; i++ {
+			buf.WriteString("\n")
+			buf.WriteString(string(truncateWidth([]rune(detail[i]), tWidth-1)))
+			lines++
+		}
+	}
+
 	// wrote out choices over "lines", move back to cursor (positioned at index)
 	fmt.Fprintf(buf, "\033[%dA", lines)
 	buf.Write(o.op.buf.getBackspaceSequence())
@@ -466,34 +1030,110 @@ func (o *opCompleter) CompleteRefresh() {
 func (o *opCompleter) EnterCompleteSelectMode() {
 	o.inSelectMode = true
 	o.candidateChoice = -1
+	if o.describer() != nil {
+		// reserve lines for the description
+		o.initPage()
+	}
 }
 
-func (o *opCompleter) EnterCompleteMode(offset int, candidate [][]rune) {
+func (o *opCompleter) EnterCompleteMode(offset int, candidate []Candidate) {
 	o.inCompleteMode.Store(1)
-	o.candidate = candidate
+	o.candidate = groupCandidates(candidate)
 	o.candidateOff = offset
+	o.candidateRich = false
+	for i := range candidate {
+		if candidate[i].Description != "" || candidate[i].Group != "" {
+			o.candidateRich = true
+			break
+		}
+	}
 	o.setColumnInfo()
 	o.initPage()
 	o.CompleteRefresh()
 }
 
+// groupCandidates returns candidates sorted by group, in the order in which
+// the groups first appear, preserving the order of candidates within a
+// group. The slice belongs to the completer, so a sorted copy is returned.
+func groupCandidates(candidates []Candidate) []Candidate {
+	order := make(map[string]int)
+	for _, c := range candidates {
+		if _, ok := order[c.Group]; !ok {
+			order[c.Group] = len(order)
+		}
+	}
+	if len(order) <= 1 {
+		return candidates
+	}
+	candidates = append([]Candidate(nil), candidates...)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return order[candidates[i].Group] < order[candidates[j].Group]
+	})
+	return candidates
+}
+
 func (o *opCompleter) initPage() {
 	_, tHeight := o.w.GetWidthHeight()
 	buflineCnt := o.op.buf.LineCount()      // lines taken by buffer content
 	o.linesAvail = tHeight - buflineCnt - 1 // lines available without scrolling buffer off screen, reserve one line for the guidance message
 	o.pageStartIdx = []int{0}               // first page always start at 0
 	o.curPage = 0
+	o.detailLines = 0
+	if o.inSelectMode && o.describer() != nil {
+		o.detailLines = o.linesAvail / 3
+		if o.detailLines > maxDetailLines {
+			o.detailLines = maxDetailLines
+		}
+		o.linesAvail -= o.detailLines
+	}
+}
+
+// describer returns the function describing the candidates: either
+// Config.DescribeCandidate or the Describe method of the AutoCompleter, or
+// nil if there is neither.
+func (o *opCompleter) describer() func(c Candidate) string {
+	cfg := o.op.GetConfig()
+	if cfg.DescribeCandidate != nil {
+		return cfg.DescribeCandidate
+	}
+	if describer, ok := cfg.AutoComplete.(CandidateDescriber); ok {
+		return describer.Describe
+	}
+	return nil
 }
 
 func (o *opCompleter) ExitCompleteSelectMode() {
 	o.inSelectMode = false
 	o.candidateChoice = -1
+	o.candidateWord = nil
+	if o.candidateAll != nil {
+		// list all the candidates again
+		o.candidate = o.candidateAll
+		o.candidateAll = nil
+		o.candidateFilter = nil
+		o.setColumnInfo()
+		o.initPage()
+	} else if o.detailLines != 0 {
+		o.initPage()
+	}
 }
 
 func (o *opCompleter) ExitCompleteMode(revent bool) {
+	if revent && o.candidateWord != nil {
+		// restore the word replaced by the previewed candidates
+		pos := o.op.buf.Pos()
+		o.op.buf.ReplaceRange(pos-o.candidateOff, pos, o.candidateWord)
+	}
 	o.inCompleteMode.Store(0)
 	o.candidate = nil
+	o.candidateAll = nil
+	o.candidateFilter = nil
 	o.candidateOff = -1
+	o.candidateAfter = 0
 	o.candidateSource = nil
+	o.cache = nil
+	o.inQueryMode = false
+	o.inPagerMode = false
+	o.pagerLines = nil
 	o.ExitCompleteSelectMode()
 }
@@ -0,0 +1,180 @@
+package readline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CobraDirective is the directive with which the output of the __complete
+// command of a cobra-based program ends (":4" is CobraNoFileComp). The
+// values are those of cobra's ShellCompDirective.
+type CobraDirective int
+
+const (
+	// CobraError means that completion failed; nothing is completed.
+	CobraError CobraDirective = 1 << iota
+	// CobraNoSpace means that no space is inserted after the candidate.
+	CobraNoSpace
+	// CobraNoFileComp means that file names are not completed if there
+	// are no candidates.
+	CobraNoFileComp
+	// CobraFilterFileExt means that the candidates are file extensions
+	// (e.g. "yaml"), and that file names with these extensions are
+	// completed instead.
+	CobraFilterFileExt
+	// CobraFilterDirs means that directory names are completed instead of
+	// the candidates; if there is a candidate, it is the directory in
+	// which they are completed.
+	CobraFilterDirs
+	// CobraKeepOrder means that the candidates are not sorted.
+	CobraKeepOrder
+)
+
+// CobraCompleter completes the arguments of a cobra-based program (see
+// https://github.com/spf13/cobra), e.g. in a shell for the program where the
+// line contains its arguments. The program is run as
+// `Command __complete args... word`, where args are the words before the
+// word under the cursor; each line of its output is a candidate, optionally
+// followed by a tab and a description, and the last line is a directive. The
+// words are parsed like shell words, as with PrefixCompleter.Tokenize.
+//
+// CobraCompleter implements ContextCompleter, so it runs asynchronously if it
+// is used in Config.AutoComplete.
+type CobraCompleter struct {
+	// Command is the program and the arguments that precede "__complete",
+	// e.g. []string{"mycli"}.
+	Command []string
+	// Func, if set, is called instead of running Command, with the
+	// arguments that follow "__complete"; it must return the output of the
+	// __complete command, e.g. from executing a cobra.Command in process.
+	Func func(ctx context.Context, args []string) (output []byte, err error)
+	// Dir is the directory in which Command is run, and against which
+	// relative paths are resolved when file names are completed; if it is
+	// empty, the current working directory is used.
+	Dir string
+}
+
+var (
+	_ AutoCompleter    = (*CobraCompleter)(nil)
+	_ RichCompleter    = (*CobraCompleter)(nil)
+	_ ContextCompleter = (*CobraCompleter)(nil)
+)
+
+func (c *CobraCompleter) Do(line []rune, pos int) (newLine [][]rune, length int) {
+	return completionsToSuffixes(line, pos, c.DoRich(line, pos))
+}
+
+// DoRich implements RichCompleter: it calls DoContext without a deadline;
+// errors result in no candidates.
+func (c *CobraCompleter) DoRich(line []rune, pos int) Completions {
+	completions, err := c.DoContext(context.Background(), line, pos)
+	if err != nil {
+		return Completions{}
+	}
+	return completions
+}
+
+// DoContext implements ContextCompleter.
+func (c *CobraCompleter) DoContext(ctx context.Context, line []rune, pos int) (completions Completions, err error) {
+	start, before := wordStart(line, pos)
+	var args []string
+	for _, w := range before {
+		args = append(args, string(unquoteWord(line[w.start:w.end])))
+	}
+	word := line[start:pos]
+	typed := string(unquoteWord(word))
+	args = append(args, typed)
+
+	var output []byte
+	if c.Func != nil {
+		output, err = c.Func(ctx, args)
+	} else if len(c.Command) != 0 {
+		cmdArgs := append([]string{}, c.Command[1:]...)
+		cmdArgs = append(cmdArgs, "__complete")
+		cmd := exec.CommandContext(ctx, c.Command[0], append(cmdArgs, args...)...)
+		cmd.Dir = c.Dir
+		output, err = cmd.Output()
+	} else {
+		err = errors.New("CobraCompleter has no Command")
+	}
+	if err != nil {
+		return
+	}
+	values, directive, err := parseCobraOutput(output)
+	if err != nil {
+		return
+	}
+
+	files := &FileCompleter{Dir: c.Dir}
+	switch {
+	case directive&CobraError != 0:
+		return completions, errors.New("completion failed")
+	case directive&CobraFilterFileExt != 0:
+		for _, v := range values {
+			files.Extensions = append(files.Extensions, "."+v.Text)
+		}
+		return files.DoRich(line, pos), nil
+	case directive&CobraFilterDirs != 0:
+		files.DirsOnly = true
+		if len(values) != 0 && filepath.IsAbs(values[0].Text) {
+			files.Dir = values[0].Text
+		} else if len(values) != 0 {
+			files.Dir = filepath.Join(c.Dir, values[0].Text)
+		}
+		return files.DoRich(line, pos), nil
+	case len(values) == 0 && directive&CobraNoFileComp == 0:
+		return files.DoRich(line, pos), nil
+	}
+
+	completions.Offset = len(word)
+	completions.After = wordEnd(line, pos) - pos
+	quote := wordQuote(word)
+	for _, v := range values {
+		if !strings.HasPrefix(v.Text, typed) {
+			continue
+		}
+		v.Display = v.Text
+		v.Text = string(quoteWord([]rune(v.Text), quote))
+		if directive&CobraNoSpace == 0 {
+			v.Suffix = " "
+		}
+		completions.Candidates = append(completions.Candidates, v)
+	}
+	if directive&CobraKeepOrder == 0 {
+		sort.SliceStable(completions.Candidates, func(i, j int) bool {
+			return completions.Candidates[i].Display < completions.Candidates[j].Display
+		})
+	}
+	return
+}
+
+// parseCobraOutput parses the output of a __complete command: candidates
+// with their descriptions, and the final directive. Active help messages
+// are ignored.
+func parseCobraOutput(output []byte) (values []Candidate, directive CobraDirective, err error) {
+	lines := strings.Split(strings.TrimRight(string(bytes.ReplaceAll(output, []byte("\r\n"), []byte("\n"))), "\n"), "\n")
+	last := lines[len(lines)-1]
+	if !strings.HasPrefix(last, ":") {
+		return nil, 0, errors.New("missing directive in completion output")
+	}
+	d, err := strconv.Atoi(last[1:])
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid directive in completion output: %q", last)
+	}
+	for _, line := range lines[:len(lines)-1] {
+		if line == "" || strings.HasPrefix(line, "_activeHelp_ ") {
+			continue
+		}
+		var c Candidate
+		c.Text, c.Description, _ = strings.Cut(line, "\t")
+		values = append(values, c)
+	}
+	return values, CobraDirective(d), nil
+}
@@ -0,0 +1,107 @@
+package readline
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// cobraStub is a shell script that behaves like the __complete command of a
+// cobra-based program with the subcommands get and logs.
+const cobraStub = `#!/bin/sh
+[ "$1" = __complete ] || exit 1
+shift
+case "$1 $#" in
+" 1"|"g 1")
+	printf 'get\tDisplay resources\nlogs\tPrint the logs\n_activeHelp_ some help\n:4\n' ;;
+"get 2")
+	printf 'pods\npod-templates\nservices\n:36\n' ;;
+"get 3")
+	printf '%s\n' --output= :6 ;;
+"logs 2")
+	printf 'txt\n:8\n' ;;
+"logs 3")
+	printf 'sub\n:16\n' ;;
+*)
+	printf ':1\n' ;;
+esac
+`
+
+func TestCobraCompleter(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub is a shell script")
+	}
+	dir := t.TempDir()
+	stub := filepath.Join(dir, "stub")
+	if err := os.WriteFile(stub, []byte(cobraStub), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "b.go", "sub/x", "sub/y/z"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type result struct {
+		texts, descriptions, suffixes []string
+		offset                        int
+	}
+	completer := &CobraCompleter{Command: []string{stub}, Dir: dir}
+	testCases := []struct {
+		line     string
+		expected result
+	}{
+		{"", result{[]string{"get", "logs"}, []string{"Display resources", "Print the logs"}, []string{" ", " "}, 0}},
+		{"g", result{[]string{"get"}, []string{"Display resources"}, []string{" "}, 1}},
+		// KeepOrder, and the candidates are filtered by the typed word:
+		{"get po", result{[]string{"pods", "pod-templates"}, []string{"", ""}, []string{" ", " "}, 2}},
+		{"get 'po", result{[]string{"'pods'", "'pod-templates'"}, []string{"", ""}, []string{" ", " "}, 3}},
+		// NoSpace:
+		{"get pods --", result{[]string{"--output="}, []string{""}, []string{""}, 2}},
+		// FilterFileExt:
+		{"logs ", result{[]string{"a.txt", "sub"}, []string{"", ""}, []string{" ", "/"}, 0}},
+		// FilterDirs:
+		{"logs a ", result{[]string{"y"}, []string{""}, []string{"/"}, 0}},
+		// Error:
+		{"bogus ", result{}},
+	}
+	for _, tc := range testCases {
+		var r result
+		completions := completer.DoRich([]rune(tc.line), len([]rune(tc.line)))
+		for _, c := range completions.Candidates {
+			r.texts = append(r.texts, c.Text)
+			r.descriptions = append(r.descriptions, c.Description)
+			r.suffixes = append(r.suffixes, c.Suffix)
+		}
+		r.offset = completions.Offset
+		if !reflect.DeepEqual(r, tc.expected) {
+			t.Errorf("completing %q: expected %v, got %v", tc.line, tc.expected, r)
+		}
+	}
+
+	// in process, with file completion by default:
+	var args []string
+	completer = &CobraCompleter{
+		Func: func(ctx context.Context, a []string) ([]byte, error) {
+			args = a
+			return []byte(":0\n"), nil
+		},
+		Dir: dir,
+	}
+	line := []rune(`cat "my file" b`)
+	completions, err := completer.DoContext(context.Background(), line, len(line))
+	if err != nil || len(completions.Candidates) != 1 || completions.Candidates[0].Text != "b.go" {
+		t.Errorf("unexpected completions: %v, %v", completions, err)
+	}
+	if strings.Join(args, "|") != "cat|my file|b" {
+		t.Errorf("unexpected arguments: %q", args)
+	}
+}
@@ -0,0 +1,190 @@
+package readline
+
+import (
+	"context"
+	"time"
+
+	"github.com/ergochat/readline/internal/runes"
+)
+
+// ContextCompleter is an optional interface that can be implemented by the
+// AutoCompleter in Config.AutoComplete, for completers that are slow (e.g.
+// because they query a database). If it is implemented, DoContext is called
+// instead of DoRich or Do, in a separate goroutine, so that the user can keep
+// typing: ctx is cancelled as soon as another key is pressed, or when
+// Config.CompletionTimeout expires. If the completion takes longer than
+// Config.CompletionLoadingDelay, "loading…" is displayed below the line until
+// the candidates arrive; they are discarded if the line was modified in the
+// meantime. If DoContext returns an error, the bell is rung.
+type ContextCompleter interface {
+	DoContext(ctx context.Context, line []rune, pos int) (Completions, error)
+}
+
+// ContextCompleterFunc adapts a function to the ContextCompleter interface.
+// It also implements AutoCompleter, so it can be used in Config.AutoComplete.
+type ContextCompleterFunc func(ctx context.Context, line []rune, pos int) (Completions, error)
+
+var (
+	_ AutoCompleter    = ContextCompleterFunc(nil)
+	_ RichCompleter    = ContextCompleterFunc(nil)
+	_ ContextCompleter = ContextCompleterFunc(nil)
+)
+
+func (f ContextCompleterFunc) DoContext(ctx context.Context, line []rune, pos int) (Completions, error) {
+	return f(ctx, line, pos)
+}
+
+// DoRich calls the function synchronously, without a deadline; errors
+// result in no candidates.
+func (f ContextCompleterFunc) DoRich(line []rune, pos int) Completions {
+	completions, err := f(context.Background(), line, pos)
+	if err != nil {
+		return Completions{}
+	}
+	return completions
+}
+
+func (f ContextCompleterFunc) Do(line []rune, pos int) (newLine [][]rune, length int) {
+	return completionsToSuffixes(line, pos, f.DoRich(line, pos))
+}
+
+// completionRequest is a call to ContextCompleter.DoContext in progress.
+type completionRequest struct {
+	line   []rune
+	pos    int
+	start  time.Time
+	cancel context.CancelFunc
+	// bell is whether the bell is rung if nothing can be completed, i.e.
+	// whether the completion was requested with Tab rather than by typing
+	// in complete mode
+	bell bool
+
+	done        chan struct{} // closed when the fields below are set
+	completions Completions
+	err         error
+}
+
+// startRequest calls completer asynchronously, replacing the pending
+// request if any.
+func (o *opCompleter) startRequest(completer ContextCompleter, line []rune, pos int) {
+	o.cancelRequest()
+	cfg := o.op.GetConfig()
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if cfg.CompletionTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), cfg.CompletionTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	req := &completionRequest{
+		line:   line,
+		pos:    pos,
+		start:  time.Now(),
+		cancel: cancel,
+		bell:   !o.IsInCompleteMode(),
+		done:   make(chan struct{}),
+	}
+	o.pending = req
+	go func() {
+		defer close(req.done)
+		req.completions, req.err = completer.DoContext(ctx, runes.Copy(line), pos)
+		if req.err == nil && ctx.Err() != nil {
+			req.err = ctx.Err()
+		}
+	}()
+}
+
+// cancelRequest cancels the pending request, if any, and erases its
+// loading indicator.
+func (o *opCompleter) cancelRequest() {
+	if o.pending == nil {
+		return
+	}
+	o.pending.cancel()
+	o.pending = nil
+	if o.loading {
+		o.loading = false
+		o.op.m.Lock()
+		o.op.buf.Refresh(nil)
+		o.CompleteRefresh()
+		o.op.m.Unlock()
+	}
+}
+
+// wake returns a channel that is closed when deadline is, or when the
+// pending request needs attention: when it is done, or when its loading
+// indicator should be displayed. stop must be called when the channel is no
+// longer needed.
+func (o *opCompleter) wake(deadline chan struct{}) (wake chan struct{}, stop func()) {
+	req := o.pending
+	wake = make(chan struct{})
+	stopChan := make(chan struct{})
+	var timer *time.Timer
+	var timeout <-chan time.Time
+	if !o.loading {
+		timer = time.NewTimer(o.op.GetConfig().CompletionLoadingDelay - time.Since(req.start))
+		timeout = timer.C
+	}
+	go func() {
+		select {
+		case <-deadline:
+		case <-req.done:
+		case <-timeout:
+		case <-stopChan:
+			return
+		}
+		close(wake)
+	}()
+	return wake, func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		close(stopChan)
+	}
+}
+
+// handleWake handles the pending request after the channel returned by
+// wake was closed.
+func (o *opCompleter) handleWake() {
+	req := o.pending
+	select {
+	case <-req.done:
+		o.finishRequest()
+	default:
+		o.loading = true
+		o.op.m.Lock()
+		o.loadingRefresh()
+		o.op.m.Unlock()
+	}
+}
+
+// finishRequest displays the result of the pending request, which is done.
+func (o *opCompleter) finishRequest() {
+	req := o.pending
+	o.pending = nil
+	o.loading = false
+	req.cancel()
+
+	buf := o.op.buf
+	ok := true
+	if req.err != nil {
+		ok = false
+	} else if buf.Pos() == req.pos && runes.Equal(buf.Runes(), req.line) {
+		o.candidateAfter = clampAfter(req.completions.After, req.pos, req.line)
+		o.cacheCompletions(req.line, req.pos, req.completions)
+		ok = o.showCandidates(req.line, req.pos, req.completions.Candidates, clampOffset(req.completions.Offset, req.pos))
+	}
+	if !ok && req.bell {
+		o.w.Bell()
+	}
+
+	o.op.m.Lock()
+	defer o.op.m.Unlock()
+	buf.Refresh(nil)
+	o.CompleteRefresh()
+}
+
+// loadingRefresh displays the loading indicator below the line.
+func (o *opCompleter) loadingRefresh() {
+	o.messageRefresh("loading…")
+}
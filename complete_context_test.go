@@ -0,0 +1,102 @@
+package readline
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer that can be written and read concurrently.
+type syncBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Reset() {
+	b.Lock()
+	defer b.Unlock()
+	b.buf.Reset()
+}
+
+// waitFor waits until s is written.
+func (b *syncBuffer) waitFor(s string) bool {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		b.Lock()
+		found := strings.Contains(b.buf.String(), s)
+		b.Unlock()
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+func TestContextCompleter(t *testing.T) {
+	words := []string{"github", "gitlab", "gitea"}
+	cancelled := make(chan error, 2)
+	completer := ContextCompleterFunc(func(ctx context.Context, line []rune, pos int) (c Completions, err error) {
+		if strings.HasPrefix(string(line[:pos]), "slow") {
+			<-ctx.Done()
+			cancelled <- ctx.Err()
+			return c, ctx.Err()
+		}
+		for _, word := range words {
+			if strings.HasPrefix(word, string(line[:pos])) {
+				c.Candidates = append(c.Candidates, Candidate{Text: word, Suffix: " "})
+			}
+		}
+		c.Offset = pos
+		return
+	})
+
+	stdin, input := io.Pipe()
+	var output syncBuffer
+	cfg := &Config{
+		AutoComplete:           completer,
+		CompletionTimeout:      100 * time.Millisecond,
+		CompletionLoadingDelay: time.Millisecond,
+		Stdout:                 &output,
+	}
+	rl := newTestInstanceFromReader(t, cfg, stdin)
+	go func() {
+		defer input.Close()
+		for _, step := range []struct{ keys, expected string }{
+			// the common prefix is inserted (and the bell rung), then the
+			// candidates are listed:
+			{"gi\t", "\a"},
+			{"\t", "gitea"},
+			{"\r", ""},
+			// typing cancels the completion:
+			{"slow\t", "loading…"},
+			{"er\r", ""},
+			// the bell is rung when the completion times out:
+			{"slowest\t", "\a"},
+			{"\r", ""},
+		} {
+			output.Reset()
+			io.WriteString(input, step.keys)
+			if !output.waitFor(step.expected) {
+				t.Errorf("after %q, %q was not displayed", step.keys, step.expected)
+				return
+			}
+		}
+	}()
+	assertReadLine(t, rl, "git")
+	assertReadLine(t, rl, "slower")
+	assertReadLine(t, rl, "slowest")
+	for _, expected := range []error{context.Canceled, context.DeadlineExceeded} {
+		if err := <-cancelled; err != expected {
+			t.Fatalf("expected %v, got %v", expected, err)
+		}
+	}
+}
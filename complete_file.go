@@ -0,0 +1,235 @@
+package readline
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileCompleter completes file system paths. The word under the cursor is
+// parsed like a shell word (it can be quoted or contain backslash-escaped
+// runes), and the inserted paths are quoted or escaped as necessary.
+// Directories are completed with a trailing "/", and other files with a
+// trailing space. It can be used on its own in Config.AutoComplete, or as a
+// node of a PrefixCompleter tree with PcItemCompleter (which requires
+// PrefixCompleter.Tokenize).
+type FileCompleter struct {
+	// Dir is the directory against which relative paths are resolved; if
+	// it is empty, the current working directory is used.
+	Dir string
+	// ShowHidden lists the files whose names start with "." even if the
+	// typed name does not start with ".".
+	ShowHidden bool
+	// DirsOnly restricts the candidates to directories.
+	DirsOnly bool
+	// Extensions, if set, restricts the candidates to directories and to
+	// the files with one of these extensions (e.g. ".go").
+	Extensions []string
+	// Filter is optional; if defined, it is called for each candidate that
+	// is not a directory with the path that would be inserted (without
+	// quoting) and the file's info, and excludes it if it returns false.
+	Filter func(path string, info os.FileInfo) bool
+	// Colors sets the Style of the candidates according to the LS_COLORS
+	// environment variable, as ls does (or to the default colors of ls, if
+	// it is unset).
+	Colors bool
+}
+
+var (
+	_ AutoCompleter = (*FileCompleter)(nil)
+	_ RichCompleter = (*FileCompleter)(nil)
+)
+
+func (f *FileCompleter) Do(line []rune, pos int) (newLine [][]rune, length int) {
+	return completionsToSuffixes(line, pos, f.DoRich(line, pos))
+}
+
+// DoRich implements RichCompleter.
+func (f *FileCompleter) DoRich(line []rune, pos int) (completions Completions) {
+	start, _ := wordStart(line, pos)
+	word := line[start:pos]
+	completions.Offset = len(word)
+	completions.After = wordEnd(line, pos) - pos
+	quote := wordQuote(word)
+	path := string(unquoteWord(word))
+	if path == "~" && quote == 0 {
+		// the home directory, which resolve only recognizes as "~/"
+		if home, err := os.UserHomeDir(); err == nil && home != "" {
+			completions.Candidates = []Candidate{{Text: "~", Display: "~/", Suffix: "/"}}
+		}
+		return
+	}
+
+	// the directory part of path, including the final slash, is kept
+	// as is in the candidates; the rest is the prefix of the name
+	dirPart, prefix := "", path
+	if i := strings.LastIndexByte(path, '/'); i != -1 {
+		dirPart, prefix = path[:i+1], path[i+1:]
+	}
+	dir := f.resolve(dirPart)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	var colors lsColors
+	if f.Colors {
+		colors = parseLSColors(os.Getenv("LS_COLORS"))
+	}
+	showHidden := f.ShowHidden || strings.HasPrefix(prefix, ".")
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || (!showHidden && strings.HasPrefix(name, ".")) {
+			continue
+		}
+		// follow symbolic links, to complete links to directories as such
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		candidate := Candidate{
+			Text:    string(quoteWord([]rune(dirPart+name), quote)),
+			Display: name,
+			Suffix:  " ",
+		}
+		if info.IsDir() {
+			candidate.Display += "/"
+			candidate.Suffix = "/"
+		} else if !f.accept(dirPart+name, info) {
+			continue
+		}
+		if f.Colors {
+			candidate.Style = colors.style(name, entry.Type(), info.Mode())
+		}
+		completions.Candidates = append(completions.Candidates, candidate)
+	}
+	return
+}
+
+// resolve returns the directory in the file system designated by dirPart,
+// the directory part of the typed path.
+func (f *FileCompleter) resolve(dirPart string) string {
+	if strings.HasPrefix(dirPart, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, dirPart[2:])
+		}
+	}
+	if dirPart == "" {
+		dirPart = "."
+	}
+	if filepath.IsAbs(dirPart) || f.Dir == "" {
+		return dirPart
+	}
+	return filepath.Join(f.Dir, dirPart)
+}
+
+// accept returns whether a file that is not a directory is a candidate.
+func (f *FileCompleter) accept(path string, info os.FileInfo) bool {
+	if f.DirsOnly {
+		return false
+	}
+	if len(f.Extensions) != 0 {
+		found := false
+		for _, ext := range f.Extensions {
+			if strings.HasSuffix(path, ext) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return f.Filter == nil || f.Filter(path, info)
+}
+
+// defaultLSColors are the colors used by ls if LS_COLORS is unset.
+const defaultLSColors = "di=01;34:ln=01;36:pi=40;33:so=01;35:bd=40;33;01:cd=40;33;01:ex=01;32"
+
+// lsColors are the SGR parameters for displaying file names, by file type
+// (e.g. "di" for directories) and by name suffix (from "*.go" patterns),
+// parsed from the format of LS_COLORS. The value of "ln" may also be
+// "target", in which case symbolic links are displayed like the files they
+// designate.
+type lsColors struct {
+	types    map[string]string
+	suffixes [][2]string
+}
+
+func parseLSColors(s string) (c lsColors) {
+	if s == "" {
+		s = defaultLSColors
+	}
+	c.types = make(map[string]string)
+	for _, entry := range strings.Split(s, ":") {
+		i := strings.IndexByte(entry, '=')
+		if i == -1 {
+			continue
+		}
+		key, value := entry[:i], entry[i+1:]
+		if !isSGRParameters(value) && !(key == "ln" && value == "target") {
+			continue
+		}
+		if strings.HasPrefix(key, "*") {
+			c.suffixes = append(c.suffixes, [2]string{key[1:], value})
+		} else {
+			c.types[key] = value
+		}
+	}
+	return
+}
+
+// style returns the escape sequence for displaying the file called name;
+// typ is its type bits as returned by os.DirEntry.Type, which does not
+// follow symbolic links, and mode is the mode of the file it designates.
+func (c lsColors) style(name string, typ, mode os.FileMode) string {
+	if typ&os.ModeSymlink != 0 && c.types["ln"] == "target" {
+		typ = mode.Type()
+	}
+	var key string
+	switch {
+	case typ&os.ModeSymlink != 0:
+		key = "ln"
+	case mode.IsDir():
+		key = "di"
+	case mode&os.ModeNamedPipe != 0:
+		key = "pi"
+	case mode&os.ModeSocket != 0:
+		key = "so"
+	case mode&os.ModeCharDevice != 0:
+		key = "cd"
+	case mode&os.ModeDevice != 0:
+		key = "bd"
+	case mode&0111 != 0:
+		key = "ex"
+	}
+	sgr := c.types[key]
+	if key == "" {
+		for _, s := range c.suffixes {
+			if strings.HasSuffix(name, s[0]) {
+				sgr = s[1]
+			}
+		}
+		if sgr == "" {
+			sgr = c.types["fi"]
+		}
+	}
+	if sgr == "" {
+		return ""
+	}
+	return "\033[" + sgr + "m"
+}
+
+// isSGRParameters returns whether s is a list of SGR parameters, such as
+// "01;34".
+func isSGRParameters(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if (r < '0' || r > '9') && r != ';' {
+			return false
+		}
+	}
+	return true
+}
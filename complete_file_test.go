@@ -0,0 +1,116 @@
+package readline
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFileCompleter(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.go", "b.txt", "my file.txt", ".hidden", "src/main.go", "sub dir/x"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("HOME", dir)
+
+	type result struct {
+		texts, suffixes []string
+		offset          int
+	}
+	complete := func(c RichCompleter, line string) (r result) {
+		completions := c.DoRich([]rune(line), len([]rune(line)))
+		for _, candidate := range completions.Candidates {
+			r.texts = append(r.texts, candidate.Text)
+			r.suffixes = append(r.suffixes, candidate.Suffix)
+		}
+		r.offset = completions.Offset
+		return
+	}
+
+	f := &FileCompleter{Dir: dir}
+	tokenized := NewPrefixCompleter(PcItem("cat", PcItemCompleter(f, PcItem("--force"))))
+	tokenized.Tokenize = true
+	testCases := []struct {
+		completer RichCompleter
+		line      string
+		expected  result
+	}{
+		{f, "", result{[]string{"a.go", "b.txt", `my\ file.txt`, "src", `sub\ dir`}, []string{" ", " ", " ", "/", "/"}, 0}},
+		{f, "cat .", result{[]string{".hidden"}, []string{" "}, 1}},
+		{f, "cat su", result{[]string{`sub\ dir`}, []string{"/"}, 2}},
+		{f, "cat src/m", result{[]string{"src/main.go"}, []string{" "}, 5}},
+		{f, `cat "my`, result{[]string{`"my file.txt"`}, []string{" "}, 3}},
+		{f, "cat sub\\ dir/", result{[]string{`sub\ dir/x`}, []string{" "}, 9}},
+		{f, "cat ~", result{[]string{"~"}, []string{"/"}, 1}},
+		{f, "cat '~", result{offset: 2}},
+		{f, "cat ~/s", result{[]string{"~/src", `~/sub\ dir`}, []string{"/", "/"}, 3}},
+		{f, "cat " + dir + "/a", result{[]string{dir + "/a.go"}, []string{" "}, len(dir) + 2}},
+		{f, "cat nonexistent/", result{offset: 12}},
+		{&FileCompleter{Dir: dir, ShowHidden: true}, ".h", result{[]string{".hidden"}, []string{" "}, 2}},
+		{&FileCompleter{Dir: dir, ShowHidden: true, Extensions: []string{".go"}}, "", result{[]string{"a.go", "src", `sub\ dir`}, []string{" ", "/", "/"}, 0}},
+		{&FileCompleter{Dir: dir, DirsOnly: true}, "", result{[]string{"src", `sub\ dir`}, []string{"/", "/"}, 0}},
+		{&FileCompleter{Dir: dir, Filter: func(path string, info os.FileInfo) bool { return strings.HasSuffix(path, ".txt") }}, "b", result{[]string{"b.txt"}, []string{" "}, 1}},
+		{&FileCompleter{Dir: dir, Filter: func(path string, info os.FileInfo) bool { return false }}, "b", result{offset: 1}},
+		{RichCompleterFunc(tokenized.doRich), "cat sr", result{[]string{"src"}, []string{"/"}, 2}},
+		{RichCompleterFunc(tokenized.doRich), "cat src/main.go --", result{[]string{"--force"}, []string{" "}, 2}},
+	}
+
+	for _, tc := range testCases {
+		if r := complete(tc.completer, tc.line); !reflect.DeepEqual(r, tc.expected) {
+			t.Errorf("completing %q: expected %v, got %v", tc.line, tc.expected, r)
+		}
+	}
+}
+
+func TestFileCompleterColors(t *testing.T) {
+	dir := t.TempDir()
+	for name, mode := range map[string]os.FileMode{"main.go": 0644, "run.sh": 0755, "notes": 0644} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("src", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"main.go": "\033[32m",
+		"run.sh":  "\033[31m",
+		"notes":   "\033[0m",
+		"src/":    "\033[01;34m",
+		"link/":   "\033[01;36m",
+	}
+	t.Setenv("LS_COLORS", "fi=0:di=01;34:ln=01;36:ex=31:*.txt=33:*.go=32")
+	completions := (&FileCompleter{Dir: dir, Colors: true}).DoRich(nil, 0)
+	styles := make(map[string]string)
+	for _, c := range completions.Candidates {
+		styles[c.Display] = c.Style
+	}
+	if !reflect.DeepEqual(styles, expected) {
+		t.Errorf("expected styles %q, got %q", expected, styles)
+	}
+
+	// links can be displayed like their targets, and invalid values are
+	// ignored:
+	expected["link/"] = "\033[01;34m"
+	expected["run.sh"] = ""
+	t.Setenv("LS_COLORS", "fi=0:di=01;34:ln=target:ex=bogus:*.txt=33:*.go=32")
+	completions = (&FileCompleter{Dir: dir, Colors: true}).DoRich(nil, 0)
+	for _, c := range completions.Candidates {
+		styles[c.Display] = c.Style
+	}
+	if !reflect.DeepEqual(styles, expected) {
+		t.Errorf("expected styles %q, got %q", expected, styles)
+	}
+}
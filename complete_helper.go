@@ -2,11 +2,51 @@ package readline
 
 import (
 	"bytes"
+	"sort"
 	"strings"
 
 	"github.com/ergochat/readline/internal/runes"
 )
 
+// MatchMode is the way in which a PrefixCompleter matches the names of
+// the tree against the typed words.
+type MatchMode int
+
+const (
+	// MatchPrefix matches the names that start with the typed word.
+	MatchPrefix MatchMode = iota
+	// MatchPrefixFold matches the names that start with the typed word,
+	// ignoring case.
+	MatchPrefixFold
+	// MatchSubstring matches the names that contain the typed word,
+	// ignoring case; earlier occurrences rank higher.
+	MatchSubstring
+	// MatchFuzzy matches the names that contain the runes of the typed
+	// word in order, ignoring case; the names are ranked by how closely
+	// they match, as in fuzzy finders.
+	MatchFuzzy
+)
+
+// match returns whether name matches the typed word, with a score (higher
+// is better) to rank the matches.
+func (m MatchMode) match(name, word []rune) (score int, ok bool) {
+	switch m {
+	case MatchPrefixFold:
+		return 0, runes.HasPrefixFold(name, word)
+	case MatchSubstring:
+		if len(word) == 0 {
+			return 0, true
+		}
+		idx := runes.IndexAllEx(name, word, true)
+		return -idx, idx != -1
+	case MatchFuzzy:
+		score, _, ok = runes.FuzzyMatch(name, word, true)
+		return score, ok
+	default:
+		return 0, runes.HasPrefix(name, word)
+	}
+}
+
 // PrefixCompleter implements AutoCompleter via a recursive tree.
 type PrefixCompleter struct {
 	// Name is the name of a command, subcommand, or argument eligible for completion.
@@ -17,6 +57,31 @@ type PrefixCompleter struct {
 	Callback func(string) []string
 	// Children is a list of possible completions that can follow the current node.
 	Children []*PrefixCompleter
+	// Completer is optional; if defined, it completes the word associated
+	// with the current node in place of Name and Callback, and any word is
+	// accepted at this position (e.g. a FileCompleter). It requires Tokenize.
+	Completer RichCompleter
+	// Tokenize enables shell-like parsing of the line; it is only consulted
+	// on the root of the tree. Words are separated by any amount of
+	// whitespace, and can be quoted or contain backslash-escaped runes; the
+	// word under the cursor is completed, and the inserted candidates are
+	// quoted or escaped as necessary.
+	Tokenize bool
+	// Match selects how names are matched against the typed words; like
+	// Tokenize, it is only consulted on the root of the tree. Modes other
+	// than MatchPrefix ignore case, and imply Tokenize: the typed word is
+	// replaced by the name, in its canonical casing. The candidates are
+	// ordered by their score. Since the typed word may not be a prefix of
+	// the candidates, these modes require the PrefixCompleter to be
+	// Config.AutoComplete itself: Do, which is called for types embedding
+	// a PrefixCompleter, only returns the candidates that extend the typed
+	// word.
+	Match MatchMode
+	// Description is optional; it is displayed by Tree and Help.
+	Description string
+	// Handler is optional; it runs the command named by the path from the
+	// root to this node (see Dispatch).
+	Handler CommandHandler
 
 	nameRunes []rune // just a cache
 }
@@ -37,7 +102,13 @@ func prefixPrint(p *PrefixCompleter, prefix string, level int, buf *bytes.Buffer
 			buf.WriteString(strings.Repeat("─", (level*4)-2))
 			buf.WriteString(" ")
 		}
-		buf.WriteString(p.Name)
+		if p.Description != "" {
+			buf.WriteString(strings.TrimRight(p.Name, " "))
+			buf.WriteString("  ")
+			buf.WriteString(p.Description)
+		} else {
+			buf.WriteString(p.Name)
+		}
 		buf.WriteByte('\n')
 		level++
 	}
@@ -96,17 +167,128 @@ func PcItemDynamic(callback func(string) []string, pc ...*PrefixCompleter) *Pref
 	}
 }
 
+func PcItemCompleter(completer RichCompleter, pc ...*PrefixCompleter) *PrefixCompleter {
+	return &PrefixCompleter{
+		Completer: completer,
+		Children:  pc,
+	}
+}
+
 func (p *PrefixCompleter) Do(line []rune, pos int) (newLine [][]rune, offset int) {
+	if p.Tokenize || p.Match != MatchPrefix {
+		return completionsToSuffixes(line, pos, p.doTokenized(line, pos))
+	}
 	return doInternal(p, line, pos, line)
 }
 
+// doRich returns the candidates of p as Completions. PrefixCompleter does
+// not implement RichCompleter, so that the Do or DoRich methods of types
+// embedding it are called; this is only called when p is
+// Config.AutoComplete. If the cursor is in the middle of a word, the whole
+// word is replaced.
+func (p *PrefixCompleter) doRich(line []rune, pos int) (completions Completions) {
+	if p.Tokenize || p.Match != MatchPrefix {
+		completions = p.doTokenized(line, pos)
+	} else {
+		newLine, offset := doInternal(p, line, pos, line)
+		completions = suffixesToCompletions(line, pos, newLine, offset)
+	}
+	completions.After = wordEnd(line, pos) - pos
+	return
+}
+
+func (p *PrefixCompleter) prefixCompleter() *PrefixCompleter {
+	return p
+}
+
+// childNames returns the names of the children of p, along with the
+// corresponding child, for completing line.
+func (p *PrefixCompleter) childNames(line []rune, f func(name string, child *PrefixCompleter) (stop bool)) {
+	for _, child := range p.Children {
+		if child.Completer != nil {
+			continue
+		} else if child.Callback != nil {
+			for _, name := range child.Callback(string(line)) {
+				if f(name, child) {
+					return
+				}
+			}
+		} else if f(strings.TrimRight(child.Name, " "), child) {
+			return
+		}
+	}
+}
+
+// doTokenized implements completion with Tokenize: the line is split into
+// words, the words before the one under the cursor select a path in the
+// tree, and the children at the end of the path complete the last word.
+func (p *PrefixCompleter) doTokenized(line []rune, pos int) (completions Completions) {
+	start, words := wordStart(line, pos)
+
+	node := p
+	for _, w := range words {
+		word := string(unquoteWord(line[w.start:w.end]))
+		var next *PrefixCompleter
+		node.childNames(line, func(name string, child *PrefixCompleter) bool {
+			if name == word || (p.Match != MatchPrefix && strings.EqualFold(name, word)) {
+				next = child
+				return true
+			}
+			return false
+		})
+		if next == nil {
+			// a child with a Completer accepts any word
+			for _, child := range node.Children {
+				if child.Completer != nil {
+					next = child
+					break
+				}
+			}
+		}
+		if next == nil {
+			return
+		}
+		node = next
+	}
+
+	word := line[start:pos]
+	typed := unquoteWord(word)
+	// keep the quoting style of the word, if it has one
+	quote := wordQuote(word)
+	var scores []int
+	node.childNames(line, func(name string, child *PrefixCompleter) bool {
+		if score, ok := p.Match.match([]rune(name), typed); name != "" && ok {
+			completions.Candidates = append(completions.Candidates, Candidate{
+				Text:    string(quoteWord([]rune(name), quote)),
+				Display: name,
+				Suffix:  " ",
+			})
+			scores = append(scores, score)
+		}
+		return false
+	})
+	if p.Match != MatchPrefix {
+		sort.Stable(candidatesByScore{completions.Candidates, scores})
+	}
+	for _, child := range node.Children {
+		if child.Completer != nil {
+			// the completer completes the same word, at the same offset
+			completions.Candidates = append(completions.Candidates, child.Completer.DoRich(line, pos).Candidates...)
+		}
+	}
+	completions.Offset = pos - start
+	return
+}
+
 func doInternal(p *PrefixCompleter, line []rune, pos int, origLine []rune) (newLine [][]rune, offset int) {
 	line = runes.TrimSpaceLeft(line[:pos])
 	goNext := false
 	var lineCompleter *PrefixCompleter
 	for _, child := range p.Children {
 		var childNames [][]rune
-		if child.Callback != nil {
+		if child.Completer != nil {
+			continue // only supported with Tokenize
+		} else if child.Callback != nil {
 			childNames = child.getDynamicNames(origLine)
 		} else {
 			childNames = make([][]rune, 1)
@@ -154,3 +336,18 @@ func doInternal(p *PrefixCompleter, line []rune, pos int, origLine []rune) (newL
 	}
 	return
 }
+
+// candidatesByScore sorts candidates by decreasing score.
+type candidatesByScore struct {
+	candidates []Candidate
+	scores     []int
+}
+
+func (c candidatesByScore) Len() int { return len(c.candidates) }
+
+func (c candidatesByScore) Less(i, j int) bool { return c.scores[i] > c.scores[j] }
+
+func (c candidatesByScore) Swap(i, j int) {
+	c.candidates[i], c.candidates[j] = c.candidates[j], c.candidates[i]
+	c.scores[i], c.scores[j] = c.scores[j], c.scores[i]
+}
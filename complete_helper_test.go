@@ -0,0 +1,158 @@
+package readline
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPrefixCompleterTokenize(t *testing.T) {
+	files := func(string) []string {
+		return []string{"my file.txt", "my folder", "it's", "plain"}
+	}
+	completer := NewPrefixCompleter(
+		PcItem("open", PcItemDynamic(files, PcItem("--force"))),
+		PcItem("cd"),
+	)
+	completer.Tokenize = true
+
+	testCases := []struct {
+		line     string
+		expected []string
+		offset   int
+	}{
+		{"  op", []string{"open"}, 2},
+		{"open  my", []string{`my\ file.txt`, `my\ folder`}, 2},
+		{"open my\\ fi", []string{`my\ file.txt`}, 6},
+		{`open "my f`, []string{`"my file.txt"`, `"my folder"`}, 5},
+		{"open 'it", []string{`'it'\''s'`}, 3},
+		{`open "my folder" --f`, []string{"--force"}, 3},
+		{"open my\\ folder ", []string{"--force"}, 0},
+		{"bogus ", nil, 0},
+	}
+	for _, tc := range testCases {
+		line := []rune(tc.line)
+		completions := completer.doRich(line, len(line))
+		var texts []string
+		for _, c := range completions.Candidates {
+			texts = append(texts, c.Text)
+		}
+		if !reflect.DeepEqual(texts, tc.expected) || completions.Offset != tc.offset {
+			t.Errorf("completing %q: expected %q at offset %d, got %q at offset %d", tc.line, tc.expected, tc.offset, texts, completions.Offset)
+		}
+	}
+
+	// in the middle of a word, the rest of the word is replaced too:
+	line := []rune(`open "my fo\ld er" x`)
+	completions := completer.doRich(line, 11)
+	if len(completions.Candidates) != 1 || completions.Offset != 6 || completions.After != 7 {
+		t.Errorf("unexpected completions in the middle of a word: %+v", completions)
+	}
+}
+
+func TestPrefixCompleterMatch(t *testing.T) {
+	completer := NewPrefixCompleter(
+		PcItem("Show", PcItem("Status"), PcItem("stats")),
+		PcItem("shutdown"),
+		PcItem("history"),
+		PcItem("set-hostname"),
+	)
+
+	testCases := []struct {
+		match    MatchMode
+		line     string
+		expected []string
+		offset   int
+	}{
+		{MatchPrefix, "sh", []string{"shutdown "}, 2},
+		{MatchPrefixFold, "sh", []string{"Show", "shutdown"}, 2},
+		{MatchPrefixFold, "SHOW st", []string{"Status", "stats"}, 2},
+		{MatchPrefixFold, "show STATS", []string{"stats"}, 5},
+		// earlier occurrences rank higher:
+		{MatchSubstring, "st", []string{"history", "set-hostname"}, 2},
+		{MatchSubstring, "HOST", []string{"set-hostname"}, 4},
+		{MatchFuzzy, "shn", []string{"shutdown", "set-hostname"}, 3},
+		{MatchFuzzy, "xyz", nil, 3},
+		{MatchFuzzy, "", []string{"Show", "shutdown", "history", "set-hostname"}, 0},
+	}
+	for _, tc := range testCases {
+		completer.Match = tc.match
+		line := []rune(tc.line)
+		completions := completer.doRich(line, len(line))
+		var texts []string
+		for _, c := range completions.Candidates {
+			texts = append(texts, c.Text)
+		}
+		if !reflect.DeepEqual(texts, tc.expected) || completions.Offset != tc.offset {
+			t.Errorf("completing %q with mode %d: expected %q at offset %d, got %q at offset %d", tc.line, tc.match, tc.expected, tc.offset, texts, completions.Offset)
+		}
+	}
+
+	// the common prefix of the candidates replaces the word only if it
+	// starts with the word:
+	completer = NewPrefixCompleter(PcItem("abcxy"), PcItem("abcdxy"), PcItem("Qrsab"), PcItem("Qrsabc"))
+	completer.Match = MatchSubstring
+	rl := newTestInstance(t, &Config{AutoComplete: completer}, "xy\t\r"+"qrs\t\r")
+	assertReadLine(t, rl, "xy")
+	assertReadLine(t, rl, "Qrsab")
+}
+
+func TestQuoteWord(t *testing.T) {
+	for _, word := range []string{"plain", "with space", `it's "quoted"`, `$HOME\path`} {
+		for _, quote := range []rune{0, '\'', '"'} {
+			quoted := quoteWord([]rune(word), quote)
+			if words := splitWords(quoted); len(words) != 1 || words[0].end != len(quoted) {
+				t.Errorf("%q quoted as %q, which is not a single word", word, string(quoted))
+			}
+			if unquoted := string(unquoteWord(quoted)); unquoted != word {
+				t.Errorf("%q quoted as %q, unquoted as %q", word, string(quoted), unquoted)
+			}
+		}
+	}
+}
+
+// aliasCompleter overrides the Do method of the PrefixCompleter it embeds.
+type aliasCompleter struct {
+	*PrefixCompleter
+}
+
+func (a aliasCompleter) Do(line []rune, pos int) (newLine [][]rune, length int) {
+	if string(line[:pos]) == "ll" {
+		return [][]rune{[]rune(" -l ")}, 0
+	}
+	return a.PrefixCompleter.Do(line, pos)
+}
+
+// richAliasCompleter overrides DoRich instead.
+type richAliasCompleter struct {
+	*PrefixCompleter
+}
+
+func (a richAliasCompleter) DoRich(line []rune, pos int) Completions {
+	return Completions{Candidates: []Candidate{{Text: "custom"}}, Offset: pos}
+}
+
+// plainEmbedder embeds a PrefixCompleter without overriding anything.
+type plainEmbedder struct {
+	*PrefixCompleter
+}
+
+func TestEmbeddedPrefixCompleter(t *testing.T) {
+	completer := aliasCompleter{NewPrefixCompleter(PcItem("ls"))}
+	rl := newTestInstance(t, &Config{AutoComplete: completer}, "ll\t\r"+"l\t\r")
+	// the Do method of the embedding type is called:
+	assertReadLine(t, rl, "ll -l ")
+	assertReadLine(t, rl, "ls ")
+
+	// so is its DoRich method:
+	rl = newTestInstance(t, &Config{AutoComplete: richAliasCompleter{NewPrefixCompleter(PcItem("hello"))}}, "h\t\r")
+	assertReadLine(t, rl, "custom")
+
+	// in the middle of a word, the rest of the word is replaced, as with
+	// the PrefixCompleter itself:
+	tree := NewPrefixCompleter(PcItem("git", PcItem("checkout"), PcItem("commit")))
+	input := "git checkout\x1b[D\x1b[D\x1b[D\x1b[D\t\r"
+	for _, completer := range []AutoCompleter{tree, plainEmbedder{tree}} {
+		rl = newTestInstance(t, &Config{AutoComplete: completer}, input)
+		assertReadLine(t, rl, "git checkout ")
+	}
+}
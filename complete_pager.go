@@ -0,0 +1,122 @@
+package readline
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+// IsInQueryMode returns whether the user is asked whether to display all the
+// candidates, or whether the pager is displaying them. These are sub-modes
+// of complete mode, in which every key is handled by HandleQuery.
+func (o *opCompleter) IsInQueryMode() bool {
+	return o.inQueryMode || o.inPagerMode
+}
+
+// HandleQuery handles a key pressed in query mode.
+func (o *opCompleter) HandleQuery(r rune) {
+	if o.inQueryMode {
+		switch r {
+		case 'y', 'Y', ' ':
+			o.inQueryMode = false
+			o.inPagerMode = true
+			o.pagerLines = o.candidateLines()
+			o.pagerPrint(o.pagerPageSize())
+		case 'n', 'N', CharBackspace, CharBell, CharInterrupt:
+			o.exitQueryMode()
+		default:
+			o.w.Bell()
+		}
+		return
+	}
+
+	switch r {
+	case ' ', 'y', 'Y':
+		o.pagerPrint(o.pagerPageSize())
+	case CharEnter, CharCtrlJ:
+		o.pagerPrint(1)
+	case 'q', 'Q', 'n', 'N', CharBackspace, CharBell, CharInterrupt:
+		o.exitQueryMode()
+	default:
+		o.w.Bell()
+	}
+}
+
+// exitQueryMode exits complete mode, erasing the question or the pager's
+// prompt below the line.
+func (o *opCompleter) exitQueryMode() {
+	o.ExitCompleteMode(false)
+	o.op.m.Lock()
+	defer o.op.m.Unlock()
+	o.op.buf.Refresh(nil)
+}
+
+// pagerPageSize returns the number of lines of candidates printed by the
+// pager at once: those that fit above the line and the pager's prompt.
+func (o *opCompleter) pagerPageSize() int {
+	_, tHeight := o.w.GetWidthHeight()
+	if n := tHeight - o.op.buf.LineCount() - 1; n > 1 {
+		return n
+	}
+	return 1
+}
+
+// pagerPrint prints the next n lines of candidates above the line, and
+// exits complete mode after the last one.
+func (o *opCompleter) pagerPrint(n int) {
+	if n > len(o.pagerLines) {
+		n = len(o.pagerLines)
+	}
+	text := strings.Join(o.pagerLines[:n], "\n") + "\n"
+	o.pagerLines = o.pagerLines[n:]
+	if len(o.pagerLines) == 0 {
+		o.ExitCompleteMode(false)
+	}
+
+	o.op.m.Lock()
+	defer o.op.m.Unlock()
+	o.op.buf.Refresh(func() {
+		o.w.Write([]byte(text))
+		// the prompt now starts at the beginning of a line
+		o.op.buf.ppos = 0
+	})
+	o.CompleteRefresh()
+}
+
+// candidateLines returns the lines of the list of all the candidates, laid
+// out as by CompleteRefresh.
+func (o *opCompleter) candidateLines() (lines []string) {
+	tWidth, _ := o.w.GetWidthHeight()
+	var line strings.Builder
+	colIdx := 0
+	for i := range o.candidate {
+		c := &o.candidate[i]
+		if o.candidateRich && c.Group != "" && (i == 0 || c.Group != o.candidate[i-1].Group) {
+			lines = append(lines, "\033[1m"+string(truncateWidth([]rune(c.Group), tWidth-1))+"\033[0m")
+		}
+		text, _ := o.formatCandidate(c, false)
+		line.WriteString(text)
+		colIdx++
+		if colIdx >= o.candidateColNum {
+			lines = append(lines, line.String())
+			line.Reset()
+			colIdx = 0
+		}
+	}
+	if colIdx > 0 {
+		lines = append(lines, line.String())
+	}
+	return
+}
+
+// messageRefresh displays a single-line message below the line.
+func (o *opCompleter) messageRefresh(message string) {
+	buf := bufio.NewWriter(o.w)
+	lineCnt := o.op.buf.CursorLineCount()
+	buf.Write(bytes.Repeat([]byte("\n"), lineCnt))
+	buf.WriteString("\033[J")
+	buf.WriteString(message)
+	buf.WriteString("\033[1A")
+	buf.Write(o.op.buf.getBackspaceSequence())
+	buf.Flush()
+}
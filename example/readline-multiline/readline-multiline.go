@@ -8,34 +8,29 @@ import (
 
 func main() {
 	rl, err := readline.NewEx(&readline.Config{
-		Prompt:                 "> ",
-		HistoryFile:            "/tmp/readline-multiline",
-		DisableAutoSaveHistory: true,
+		Prompt:             "> ",
+		ContinuationPrompt: ">>> ",
+		HistoryFile:        "/tmp/readline-multiline",
+		// Enter inserts a newline until the statement ends with ';'
+		IsInputComplete: func(text string) bool {
+			text = strings.TrimSpace(text)
+			return len(text) == 0 || strings.HasSuffix(text, ";")
+		},
 	})
 	if err != nil {
 		panic(err)
 	}
 	defer rl.Close()
 
-	var cmds []string
 	for {
-		line, err := rl.Readline()
+		cmd, err := rl.Readline()
 		if err != nil {
 			break
 		}
-		line = strings.TrimSpace(line)
-		if len(line) == 0 {
+		cmd = strings.TrimSpace(cmd)
+		if len(cmd) == 0 {
 			continue
 		}
-		cmds = append(cmds, line)
-		if !strings.HasSuffix(line, ";") {
-			rl.SetPrompt(">>> ")
-			continue
-		}
-		cmd := strings.Join(cmds, " ")
-		cmds = cmds[:0]
-		rl.SetPrompt("> ")
-		rl.SaveToHistory(cmd)
 		println(cmd)
 	}
 }
@@ -0,0 +1,292 @@
+package readline
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"sort"
+	"sync"
+	"unicode"
+
+	"github.com/ergochat/readline/internal/runes"
+)
+
+const (
+	// maximum number of matches displayed below the prompt at once
+	fuzzySearchMaxRows = 10
+)
+
+type fuzzyMatch struct {
+	entry     []rune
+	score     int
+	positions []int // indices of the runes in entry that matched the query
+}
+
+// opFuzzySearch implements an fzf-style interactive history finder: the query
+// is matched as a subsequence against every history entry, and the ranked
+// results are displayed in a scrollable list below the prompt.
+type opFuzzySearch struct {
+	mutex   sync.Mutex
+	inMode  bool
+	w       *terminal
+	buf     *runeBuffer
+	history *opHistory
+	query   []rune
+	matches []fuzzyMatch
+	total   int // number of distinct history entries considered
+	choice  int // index (in matches) of the selected match
+	top     int // index (in matches) of the first match on screen
+}
+
+func newOpFuzzySearch(w *terminal, buf *runeBuffer, history *opHistory) *opFuzzySearch {
+	return &opFuzzySearch{
+		w:       w,
+		buf:     buf,
+		history: history,
+	}
+}
+
+func (o *opFuzzySearch) IsSearchMode() bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.inMode
+}
+
+// SearchMode enters fuzzy search mode, returning false if the terminal
+// is unsuitable for displaying the list of matches.
+func (o *opFuzzySearch) SearchMode() bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	tWidth, tHeight := o.w.GetWidthHeight()
+	if tWidth <= 0 || tHeight < 3 {
+		return false
+	}
+	o.inMode = true
+	o.query = nil
+	o.update()
+	o.refresh()
+	return true
+}
+
+// HandleSearchKey processes a keypress in fuzzy search mode. If it returns
+// false, search mode was exited and the keypress may require further handling.
+func (o *opFuzzySearch) HandleSearchKey(r rune) (stayInMode bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	switch r {
+	case CharEnter, CharCtrlJ:
+		o.accept()
+		return false
+	case CharInterrupt, CharBell:
+		o.exit()
+		o.buf.Refresh(nil)
+		return false
+	case CharPrev, CharFwdSearch:
+		o.move(-1)
+	case CharNext, CharBckSearch, CharTab:
+		o.move(1)
+	case MetaPageUp:
+		o.movePage(-1)
+	case MetaPageDown:
+		o.movePage(1)
+	case CharBackspace, CharCtrlH:
+		if len(o.query) > 0 {
+			o.query = o.query[:len(o.query)-1]
+			o.update()
+		}
+	case CharCtrlU:
+		o.query = nil
+		o.update()
+	default:
+		if r < ' ' {
+			// any other control character accepts the selection,
+			// then gets processed as normal
+			o.accept()
+			return false
+		}
+		o.query = append(o.query, r)
+		o.update()
+	}
+	o.refresh()
+	return true
+}
+
+func (o *opFuzzySearch) ExitSearchMode() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.exit()
+}
+
+func (o *opFuzzySearch) exit() {
+	o.inMode = false
+	o.query = nil
+	o.matches = nil
+	o.choice, o.top, o.total = 0, 0, 0
+}
+
+// accept places the selected entry in the buffer (this also clears the list
+// of matches from the screen) and exits search mode.
+func (o *opFuzzySearch) accept() {
+	var entry []rune
+	if o.choice < len(o.matches) {
+		entry = runes.Copy(o.matches[o.choice].entry)
+	}
+	o.exit()
+	if entry != nil {
+		o.buf.Set(entry)
+	} else {
+		o.buf.Refresh(nil)
+	}
+}
+
+func (o *opFuzzySearch) move(i int) {
+	if len(o.matches) == 0 {
+		return
+	}
+	o.choice = (o.choice + i) % len(o.matches)
+	if o.choice < 0 {
+		o.choice += len(o.matches)
+	}
+}
+
+// movePage moves the selection by one screen of matches, without wrapping
+// around.
+func (o *opFuzzySearch) movePage(dir int) {
+	if len(o.matches) == 0 {
+		return
+	}
+	rows := o.rows()
+	if rows < 1 {
+		rows = 1
+	}
+	o.choice += dir * rows
+	if o.choice >= len(o.matches) {
+		o.choice = len(o.matches) - 1
+	}
+	if o.choice < 0 {
+		o.choice = 0
+	}
+}
+
+// update recomputes the ranked list of matches for the current query.
+func (o *opFuzzySearch) update() {
+	fold := historySearchFold(o.history.operation.GetConfig(), o.query)
+	seen := make(map[string]struct{})
+	o.matches = o.matches[:0]
+	o.total = 0
+	o.history.iterate(func(elem *list.Element) bool {
+		entry := elem.Value.(*hisItem).Source
+		if len(entry) == 0 {
+			return false
+		}
+		if _, ok := seen[string(entry)]; ok {
+			return false
+		}
+		seen[string(entry)] = struct{}{}
+		o.total++
+		if score, positions, ok := runes.FuzzyMatch(entry, o.query, fold); ok {
+			o.matches = append(o.matches, fuzzyMatch{entry: entry, score: score, positions: positions})
+		}
+		return false
+	})
+	// entries are visited newest-first, so a stable sort breaks ties by recency
+	sort.SliceStable(o.matches, func(i, j int) bool {
+		return o.matches[i].score > o.matches[j].score
+	})
+	o.choice, o.top = 0, 0
+}
+
+// rows returns the number of matches that can be displayed at once.
+func (o *opFuzzySearch) rows() int {
+	_, tHeight := o.w.GetWidthHeight()
+	// one line is reserved for the query
+	rows := tHeight - o.buf.LineCount() - 1
+	if rows > fuzzySearchMaxRows {
+		rows = fuzzySearchMaxRows
+	}
+	if rows < 0 {
+		rows = 0
+	}
+	return rows
+}
+
+func (o *opFuzzySearch) refresh() {
+	tWidth, _ := o.w.GetWidthHeight()
+	rows := o.rows()
+	// scroll the window of displayed matches so that the selection is visible
+	if o.choice < o.top {
+		o.top = o.choice
+	} else if o.choice >= o.top+rows {
+		o.top = o.choice - rows + 1
+	}
+
+	buf := bytes.NewBuffer(nil)
+	lineCnt := o.buf.CursorLineCount()
+	buf.Write(bytes.Repeat([]byte("\n"), lineCnt)) // move down from cursor to the query line
+	buf.WriteString("\033[J")
+	// the query line must not wrap, or moving back up would fail
+	query := truncateWidth([]rune("fuzzy-search: "+string(o.query)), tWidth-1)
+	width := tWidth - 1 - runes.WidthAll(query)
+	buf.WriteString(string(query))
+	if width > 0 {
+		buf.WriteString("\033[4m \033[0m") // _
+		width--
+	}
+	if status := fmt.Sprintf("  (%d/%d)", len(o.matches), o.total); len(status) <= width {
+		buf.WriteString(status)
+	}
+	lines := 1
+	for i := o.top; i < len(o.matches) && i < o.top+rows; i++ {
+		buf.WriteString("\n")
+		writeFuzzyMatch(buf, &o.matches[i], i == o.choice, tWidth-1)
+		lines++
+	}
+	// move back up to the cursor
+	fmt.Fprintf(buf, "\033[%dA", lines)
+	buf.Write(o.buf.getBackspaceSequence())
+	o.w.Write(buf.Bytes())
+}
+
+// writeFuzzyMatch writes a single match, truncated to width, underlining
+// the runes that matched the query.
+func writeFuzzyMatch(buf *bytes.Buffer, m *fuzzyMatch, selected bool, width int) {
+	if selected {
+		buf.WriteString("> \033[30;47m")
+	} else {
+		buf.WriteString("  ")
+	}
+	width -= 2
+	pi := 0
+	for i, r := range m.entry {
+		if unicode.IsControl(r) {
+			r = ' '
+		}
+		w := runes.Width(r)
+		if w > width {
+			break
+		}
+		width -= w
+		if pi < len(m.positions) && m.positions[pi] == i {
+			pi++
+			buf.WriteString("\033[4m")
+			buf.WriteRune(r)
+			buf.WriteString("\033[24m")
+		} else {
+			buf.WriteRune(r)
+		}
+	}
+	if selected {
+		buf.WriteString("\033[0m")
+	}
+}
+
+func (o *opFuzzySearch) RefreshIfNeeded() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.inMode {
+		o.refresh()
+	}
+}
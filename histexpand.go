@@ -0,0 +1,255 @@
+package readline
+
+import (
+	"fmt"
+	"unicode"
+
+	"github.com/ergochat/readline/internal/runes"
+)
+
+// expandHistory performs bash-style history expansion on line, where history
+// holds the previous entries, most recent first. The supported event
+// designators are !!, !n, !-n, !string and !?string[?], optionally followed
+// by a word designator (:n, :^, :$, :*, :x-y, :x-, :x*), as well as the
+// abbreviations !$, !^ and !*, and the quick substitution ^old^new^.
+func expandHistory(line []rune, history [][]rune) ([]rune, error) {
+	if len(line) > 0 && line[0] == '^' {
+		return quickSubstitute(line, history)
+	}
+
+	result := make([]rune, 0, len(line))
+	var quote rune
+	for i := 0; i < len(line); i++ {
+		r := line[i]
+		switch {
+		case quote == '\'':
+			// no expansion inside single quotes
+			if r == '\'' {
+				quote = 0
+			}
+		case r == '\\':
+			if i+1 < len(line) {
+				result = append(result, r)
+				i++
+				r = line[i]
+			}
+		case r == '\'' && quote == 0:
+			quote = r
+		case r == '"':
+			if quote == 0 {
+				quote = r
+			} else {
+				quote = 0
+			}
+		case r == '!' && i+1 < len(line) && !isHistoryExpansionStop(line[i+1]):
+			expansion, n, err := expandHistoryEvent(line[i:], history)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, expansion...)
+			i += n - 1
+			continue
+		}
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+// isHistoryExpansionStop returns whether a ! followed by r should be
+// left alone; as in bash, this includes whitespace, = and (.
+func isHistoryExpansionStop(r rune) bool {
+	return unicode.IsSpace(r) || r == '=' || r == '(' || r == '"'
+}
+
+// expandHistoryEvent expands the history reference at the start of s,
+// returning the expansion and the number of runes of s that were consumed.
+func expandHistoryEvent(s []rune, history [][]rune) (expansion []rune, n int, err error) {
+	var entry []rune
+	i := 1
+	switch c := s[1]; {
+	case c == '!':
+		entry = historyEntry(history, 0)
+		i = 2
+	case c == '$' || c == '^' || c == '*':
+		// abbreviation for !!:$ etc.; the word designator is parsed below
+		entry = historyEntry(history, 0)
+	case c == '-' || ('0' <= c && c <= '9'):
+		if c == '-' {
+			i++
+		}
+		num := 0
+		j := i
+		for ; j < len(s) && '0' <= s[j] && s[j] <= '9'; j++ {
+			num = num*10 + int(s[j]-'0')
+		}
+		if j == i {
+			return nil, 0, fmt.Errorf("%s: event not found", string(s[:j]))
+		}
+		if c == '-' {
+			entry = historyEntry(history, num-1)
+		} else {
+			entry = historyEntry(history, len(history)-num)
+		}
+		i = j
+	case c == '?':
+		j := 2
+		for j < len(s) && s[j] != '?' && s[j] != '\n' {
+			j++
+		}
+		search := s[2:j]
+		for _, h := range history {
+			if runes.IndexAll(h, search) != -1 {
+				entry = h
+				break
+			}
+		}
+		i = j
+		if i < len(s) && s[i] == '?' {
+			i++
+		}
+	default:
+		j := 1
+		for j < len(s) && !unicode.IsSpace(s[j]) && s[j] != ':' && s[j] != '"' {
+			j++
+		}
+		prefix := s[1:j]
+		for _, h := range history {
+			if runes.HasPrefix(h, prefix) {
+				entry = h
+				break
+			}
+		}
+		i = j
+	}
+	if entry == nil {
+		return nil, 0, fmt.Errorf("%s: event not found", string(s[:i]))
+	}
+
+	designator := i
+	if i < len(s) && s[i] == ':' && i+1 < len(s) && isWordDesignatorStart(s[i+1]) {
+		designator = i + 1
+	} else if i < len(s) && (s[i] == '$' || s[i] == '^' || s[i] == '*') {
+		designator = i
+	} else {
+		return runes.Copy(entry), i, nil
+	}
+
+	words := splitWords(entry)
+	from, to, m, ok := parseWordDesignator(s[designator:], len(words)-1)
+	if !ok {
+		return nil, 0, fmt.Errorf("%s: bad word specifier", string(s[:designator+m]))
+	}
+	for w := from; w <= to; w++ {
+		if w != from {
+			expansion = append(expansion, ' ')
+		}
+		expansion = append(expansion, entry[words[w].start:words[w].end]...)
+	}
+	return expansion, designator + m, nil
+}
+
+func isWordDesignatorStart(r rune) bool {
+	return ('0' <= r && r <= '9') || r == '^' || r == '$' || r == '*' || r == '-'
+}
+
+// parseWordDesignator parses a word designator at the start of s, where last
+// is the index of the last word of the event. It returns the (inclusive)
+// range of words selected, which may be empty (to == from-1), and the
+// number of runes consumed.
+func parseWordDesignator(s []rune, last int) (from, to, n int, ok bool) {
+	parseIndex := func() (int, bool) {
+		if n >= len(s) {
+			return 0, false
+		}
+		switch s[n] {
+		case '^':
+			n++
+			return 1, true
+		case '$':
+			n++
+			return last, true
+		}
+		idx := 0
+		start := n
+		for ; n < len(s) && '0' <= s[n] && s[n] <= '9'; n++ {
+			idx = idx*10 + int(s[n]-'0')
+		}
+		return idx, n != start
+	}
+
+	switch s[0] {
+	case '*':
+		n = 1
+		from, to = 1, last
+	case '-':
+		n = 1
+		from = 0
+		if to, ok = parseIndex(); !ok {
+			return
+		}
+	default:
+		if from, ok = parseIndex(); !ok {
+			return
+		}
+		to = from
+		if n < len(s) && s[n] == '*' {
+			n++
+			to = last
+		} else if n < len(s) && s[n] == '-' {
+			n++
+			if n < len(s) && isWordDesignatorStart(s[n]) && s[n] != '-' && s[n] != '*' {
+				if to, ok = parseIndex(); !ok {
+					return
+				}
+			} else {
+				to = last - 1
+			}
+		}
+	}
+	ok = from <= last+1 && to <= last && to >= from-1 && from >= 0
+	return
+}
+
+// quickSubstitute implements ^old^new^, which repeats the previous command,
+// replacing the first occurrence of old with new.
+func quickSubstitute(line []rune, history [][]rune) ([]rune, error) {
+	i := 1
+	for i < len(line) && line[i] != '^' {
+		i++
+	}
+	old := line[1:i]
+	var replacement, rest []rune
+	j := len(line)
+	if i < len(line) {
+		j = i + 1
+		for j < len(line) && line[j] != '^' {
+			j++
+		}
+		replacement = line[i+1 : j]
+		if j < len(line) {
+			rest = line[j+1:]
+		}
+	}
+
+	prev := historyEntry(history, 0)
+	if prev == nil {
+		return nil, fmt.Errorf("!!: event not found")
+	}
+	idx := runes.IndexAll(prev, old)
+	if len(old) == 0 || idx == -1 {
+		return nil, fmt.Errorf("%s: substitution failed", string(line[:j]))
+	}
+	result := make([]rune, 0, len(prev)+len(replacement)+len(rest))
+	result = append(result, prev[:idx]...)
+	result = append(result, replacement...)
+	result = append(result, prev[idx+len(old):]...)
+	result = append(result, rest...)
+	return result, nil
+}
+
+func historyEntry(history [][]rune, i int) []rune {
+	if 0 <= i && i < len(history) {
+		return history[i]
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package readline
+
+import (
+	"testing"
+)
+
+func TestHistoryExpansion(t *testing.T) {
+	// most recent first:
+	history := [][]rune{
+		[]rune(`git commit -m "fix the bug" main.go`),
+		[]rune("ls -la /tmp"),
+		[]rune("make test"),
+	}
+
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{"no expansion", "no expansion"},
+		{"!!", `git commit -m "fix the bug" main.go`},
+		{"sudo !!", `sudo git commit -m "fix the bug" main.go`},
+		{"vim !$", "vim main.go"},
+		{"echo !^", "echo commit"},
+		{"echo !*", `echo commit -m "fix the bug" main.go`},
+		{"!-2", "ls -la /tmp"},
+		{"!1", "make test"},
+		{"!3", `git commit -m "fix the bug" main.go`},
+		{"!ma", "make test"},
+		{"!?tmp?", "ls -la /tmp"},
+		{"!?tmp", "ls -la /tmp"},
+		{"echo !!:2", "echo -m"},
+		{"echo !!:3", `echo "fix the bug"`},
+		{"echo !!:1-2", "echo commit -m"},
+		{"echo !-2:2*", "echo /tmp"},
+		{"echo !-2:0-", "echo ls -la"},
+		{"echo !-2:-1", "echo ls -la"},
+		{"cd !ls:$", "cd /tmp"},
+		{"!!:0 status", "git status"},
+		{"^main^util", `git commit -m "fix the bug" util.go`},
+		{"^main^util^ -v", `git commit -m "fix the bug" util.go -v`},
+		{"echo hi!", "echo hi!"},
+		{"echo ! x != y", "echo ! x != y"},
+		{`echo '!!'`, `echo '!!'`},
+		{`echo \!!`, `echo \!!`},
+		{`echo "!!"`, `echo "git commit -m "fix the bug" main.go"`},
+	}
+	for _, test := range tests {
+		result, err := expandHistory([]rune(test.input), history)
+		if err != nil {
+			t.Errorf("expanding %q: unexpected error %v", test.input, err)
+		} else if string(result) != test.output {
+			t.Errorf("expanding %q: expected %q, got %q", test.input, test.output, string(result))
+		}
+	}
+
+	errors := []string{
+		"!nonexistent",
+		"!?nonexistent?",
+		"!-4",
+		"!4",
+		"!!:9",
+		"^foo^bar",
+	}
+	for _, input := range errors {
+		if result, err := expandHistory([]rune(input), history); err == nil {
+			t.Errorf("expanding %q: expected an error, got %q", input, string(result))
+		}
+	}
+
+	if _, err := expandHistory([]rune("!!"), nil); err == nil {
+		t.Errorf("expected an error with empty history")
+	}
+}
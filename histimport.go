@@ -0,0 +1,214 @@
+package readline
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ShellHistoryFormat identifies the format of a shell's history file.
+type ShellHistoryFormat int
+
+const (
+	// BashHistory is the format of ~/.bash_history, optionally with the
+	// "#timestamp" lines written when HISTTIMEFORMAT is set.
+	BashHistory ShellHistoryFormat = iota
+	// ZshHistory is the format of ~/.zsh_history, in either the plain or the
+	// extended (": timestamp:duration;command") format.
+	ZshHistory
+	// FishHistory is the format of ~/.local/share/fish/fish_history.
+	FishHistory
+)
+
+// shellHistoryEntry is a command parsed from a shell's history file.
+type shellHistoryEntry struct {
+	command   string
+	timestamp int64 // seconds since the epoch, or 0 if unknown
+}
+
+// parseShellHistory parses the history file of a shell, returning its
+// commands, oldest first.
+func parseShellHistory(format ShellHistoryFormat, r io.Reader) (entries []shellHistoryEntry, err error) {
+	br := bufio.NewReader(r)
+	var lines [][]byte
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) != 0 {
+			lines = append(lines, bytes.TrimRight(line, "\r\n"))
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	switch format {
+	case BashHistory:
+		entries = parseBashHistory(lines)
+	case ZshHistory:
+		entries = parseZshHistory(lines)
+	case FishHistory:
+		entries = parseFishHistory(lines)
+	default:
+		return nil, fmt.Errorf("unknown shell history format %d", format)
+	}
+
+	// entries with timestamps are not necessarily in order (e.g. zsh shells
+	// without SHARE_HISTORY append their history when they exit):
+	for _, entry := range entries {
+		if entry.timestamp == 0 {
+			return entries, nil
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].timestamp < entries[j].timestamp
+	})
+	return entries, nil
+}
+
+// ImportShellHistory parses the history file of a shell in the given format
+// from r, and merges its commands into the history (see opHistory.Merge);
+// multi-line commands become multi-line entries.
+func (o *operation) ImportShellHistory(format ShellHistoryFormat, r io.Reader) (int, error) {
+	entries, err := parseShellHistory(format, r)
+	if err != nil {
+		return 0, err
+	}
+	commands := make([][]rune, 0, len(entries))
+	for _, entry := range entries {
+		commands = append(commands, []rune(entry.command))
+	}
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.history.Merge(commands), nil
+}
+
+// parseBashTimestamp parses a "#1625097600" timestamp line.
+func parseBashTimestamp(line []byte) (timestamp int64, ok bool) {
+	if len(line) < 2 || line[0] != '#' {
+		return 0, false
+	}
+	timestamp, err := strconv.ParseInt(string(line[1:]), 10, 64)
+	return timestamp, err == nil
+}
+
+func parseBashHistory(lines [][]byte) (entries []shellHistoryEntry) {
+	// if the file has timestamps, the lines between two timestamps make up
+	// a single (multi-line) command; otherwise there is a command per line.
+	var timestamp int64
+	var command []string
+	flush := func() {
+		if len(command) != 0 {
+			entries = append(entries, shellHistoryEntry{command: strings.Join(command, "\n"), timestamp: timestamp})
+		}
+		command = nil
+	}
+	for _, line := range lines {
+		if ts, ok := parseBashTimestamp(line); ok {
+			flush()
+			timestamp = ts
+			continue
+		}
+		command = append(command, string(line))
+		if timestamp == 0 {
+			flush()
+		}
+	}
+	flush()
+	return
+}
+
+// zshMeta is the byte with which zsh escapes bytes in its history file:
+// it is followed by the original byte xor 0x20.
+const zshMeta = 0x83
+
+func unmetafyZsh(line []byte) []byte {
+	result := make([]byte, 0, len(line))
+	for i := 0; i < len(line); i++ {
+		if line[i] == zshMeta && i+1 < len(line) {
+			i++
+			result = append(result, line[i]^0x20)
+		} else {
+			result = append(result, line[i])
+		}
+	}
+	return result
+}
+
+func parseZshHistory(lines [][]byte) (entries []shellHistoryEntry) {
+	for i := 0; i < len(lines); i++ {
+		line := string(unmetafyZsh(lines[i]))
+		// a line ending with a backslash continues on the next line
+		for strings.HasSuffix(line, "\\") && i+1 < len(lines) {
+			i++
+			line = line[:len(line)-1] + "\n" + string(unmetafyZsh(lines[i]))
+		}
+
+		var timestamp int64
+		// extended format: ": <timestamp>:<duration>;<command>"
+		if strings.HasPrefix(line, ": ") {
+			if semicolon := strings.IndexByte(line, ';'); semicolon != -1 {
+				fields := strings.SplitN(line[2:semicolon], ":", 2)
+				if ts, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+					timestamp = ts
+					line = line[semicolon+1:]
+				}
+			}
+		}
+		if line != "" {
+			entries = append(entries, shellHistoryEntry{command: line, timestamp: timestamp})
+		}
+	}
+	return
+}
+
+// unescapeFish undoes the escaping of commands in fish_history, where
+// backslashes and newlines are written as \\ and \n.
+func unescapeFish(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case 'n':
+				buf.WriteByte('\n')
+				i++
+				continue
+			case '\\':
+				buf.WriteByte('\\')
+				i++
+				continue
+			}
+		}
+		buf.WriteByte(s[i])
+	}
+	return buf.String()
+}
+
+func parseFishHistory(lines [][]byte) (entries []shellHistoryEntry) {
+	// the file consists of YAML-like records:
+	// - cmd: echo hello
+	//   when: 1625097600
+	//   paths:
+	//     - hello
+	for _, line := range lines {
+		str := string(line)
+		if strings.HasPrefix(str, "- cmd: ") {
+			command := strings.TrimPrefix(str, "- cmd: ")
+			entries = append(entries, shellHistoryEntry{command: unescapeFish(command)})
+		} else if strings.HasPrefix(str, "  when: ") && len(entries) != 0 {
+			when := strings.TrimPrefix(str, "  when: ")
+			if ts, err := strconv.ParseInt(when, 10, 64); err == nil {
+				entries[len(entries)-1].timestamp = ts
+			}
+		}
+	}
+	return
+}
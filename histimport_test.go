@@ -0,0 +1,62 @@
+package readline
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseShellHistory(t *testing.T) {
+	testCases := []struct {
+		format   ShellHistoryFormat
+		input    string
+		expected []shellHistoryEntry
+	}{
+		{BashHistory, "ls\ncd /tmp\n", []shellHistoryEntry{{"ls", 0}, {"cd /tmp", 0}}},
+		{BashHistory, "#1700000000\nls\n#1700000005\nfor i in 1 2; do\necho $i\ndone\n#1700000010\npwd\n",
+			[]shellHistoryEntry{{"ls", 1700000000}, {"for i in 1 2; do\necho $i\ndone", 1700000005}, {"pwd", 1700000010}}},
+		{ZshHistory, ": 1700000010:0;ls -la\n: 1700000000:3;make\\\ntest\necho plain\n",
+			[]shellHistoryEntry{{"ls -la", 1700000010}, {"make\ntest", 1700000000}, {"echo plain", 0}}},
+		// metafied: the second byte of "ă" (0xc4 0x83) is escaped as 0x83 0xa3
+		{ZshHistory, ": 1700000000:0;echo \xc4\x83\xa3\n", []shellHistoryEntry{{"echo ă", 1700000000}}},
+		{FishHistory, "- cmd: echo hi\\\\there\n  when: 1700000020\n  paths:\n    - there\n- cmd: ls\\nls\n  when: 1700000010\n",
+			[]shellHistoryEntry{{"ls\nls", 1700000010}, {"echo hi\\there", 1700000020}}},
+	}
+	for _, tc := range testCases {
+		entries, err := parseShellHistory(tc.format, strings.NewReader(tc.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(entries, tc.expected) {
+			t.Errorf("parsing %q: expected %v, got %v", tc.input, tc.expected, entries)
+		}
+	}
+}
+
+func TestImportShellHistory(t *testing.T) {
+	rl := newTestInstance(t, &Config{}, "")
+	rl.SaveToHistory("ls")
+	n, err := rl.ImportShellHistory(BashHistory, strings.NewReader("make\nls\npwd\nmake\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 entries to be imported, got %d", n)
+	}
+	if history := rl.History(); !reflect.DeepEqual(history, []string{"ls", "make", "pwd"}) {
+		t.Errorf("unexpected history: %v", history)
+	}
+
+	// multi-line commands are imported as single entries:
+	n, err = rl.ImportShellHistory(BashHistory, strings.NewReader("#1\nfor x in a b; do\n  echo $x\ndone\n#2\ncd\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 entries to be imported, got %d", n)
+	}
+	expected := []string{"ls", "make", "pwd", "cd", "for x in a b; do\n  echo $x\ndone"}
+	if history := rl.History(); !reflect.DeepEqual(history, expected) {
+		t.Errorf("unexpected history: %#v", history)
+	}
+}
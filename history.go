@@ -3,6 +3,8 @@ package readline
 import (
 	"bufio"
 	"container/list"
+	"errors"
+	"io"
 	"os"
 	"strings"
 	"sync"
@@ -10,10 +12,76 @@ import (
 	"github.com/ergochat/readline/internal/runes"
 )
 
+// historyNamespaceSep delimits the namespace of an entry in the history
+// file: entries of the default namespace are stored as is, the others as
+// "\x1fnamespace\x1fentry".
+const historyNamespaceSep = "\x1f"
+
 type hisItem struct {
-	Source  []rune
-	Version int64
-	Tmp     []rune
+	Source    []rune
+	Version   int64
+	Tmp       []rune
+	Namespace string
+}
+
+// historyMultilineMark starts the encoding of a multi-line entry in the
+// history file, which is stored on a single line: its backslashes and
+// newlines are escaped as \\ and \n. Other entries are stored as is, so
+// that files written before multi-line entries existed still read the same.
+const historyMultilineMark = "\x1e"
+
+// encodeHistoryLine returns the line of the history file storing item.
+func encodeHistoryLine(item *hisItem) string {
+	entry := encodeHistoryEntry(string(item.Source))
+	if item.Namespace == "" {
+		return entry + "\n"
+	}
+	return historyNamespaceSep + item.Namespace + historyNamespaceSep + entry + "\n"
+}
+
+// decodeHistoryLine is the inverse of encodeHistoryLine, for a line
+// without its trailing newline.
+func decodeHistoryLine(line string) (entry, namespace string) {
+	if strings.HasPrefix(line, historyNamespaceSep) {
+		if end := strings.Index(line[1:], historyNamespaceSep); end != -1 {
+			return decodeHistoryEntry(line[end+2:]), line[1 : end+1]
+		}
+	}
+	return decodeHistoryEntry(line), ""
+}
+
+// encodeHistoryEntry encodes entry for a single line of the history file.
+func encodeHistoryEntry(entry string) string {
+	if !strings.Contains(entry, "\n") {
+		return entry
+	}
+	entry = strings.ReplaceAll(entry, "\\", "\\\\")
+	return historyMultilineMark + strings.ReplaceAll(entry, "\n", "\\n")
+}
+
+// decodeHistoryEntry is the inverse of encodeHistoryEntry.
+func decodeHistoryEntry(s string) string {
+	if !strings.HasPrefix(s, historyMultilineMark) {
+		return s
+	}
+	s = s[len(historyMultilineMark):]
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case 'n':
+				buf.WriteByte('\n')
+				i++
+				continue
+			case '\\':
+				buf.WriteByte('\\')
+				i++
+				continue
+			}
+		}
+		buf.WriteByte(s[i])
+	}
+	return buf.String()
 }
 
 func (h *hisItem) Clean() {
@@ -26,6 +94,7 @@ type opHistory struct {
 	history    *list.List
 	historyVer int64
 	current    *list.Element
+	pending    *list.Element // entry to load at the start of the next read cycle
 	fd         *os.File
 	fdLock     sync.Mutex
 	enable     bool
@@ -48,6 +117,7 @@ func (o *opHistory) isEnabled() bool {
 func (o *opHistory) Reset() {
 	o.history = list.New()
 	o.current = nil
+	o.pending = nil
 }
 
 func (o *opHistory) initHistory() {
@@ -57,15 +127,31 @@ func (o *opHistory) initHistory() {
 	}
 }
 
-// only called by newOpHistory
-func (o *opHistory) historyUpdatePath(cfg *Config) {
+// Reload discards the in-memory history and reloads it from the history
+// file; it fails if none is configured. If the file cannot be opened, the
+// history is left unchanged.
+func (o *opHistory) Reload() error {
+	cfg := o.operation.GetConfig()
+	if cfg.HistoryFile == "" {
+		return errors.New("no history file configured")
+	}
+	return o.historyUpdatePath(cfg)
+}
+
+// historyUpdatePath replaces the in-memory history with the contents of the
+// history file, once it is open. Only called by newOpHistory and Reload.
+func (o *opHistory) historyUpdatePath(cfg *Config) error {
 	o.fdLock.Lock()
 	defer o.fdLock.Unlock()
 	f, err := os.OpenFile(cfg.HistoryFile, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0666)
 	if err != nil {
-		return
+		return err
+	}
+	if o.fd != nil {
+		o.fd.Close()
 	}
 	o.fd = f
+	o.Reset()
 	r := bufio.NewReader(o.fd)
 	total := 0
 	for ; ; total++ {
@@ -78,7 +164,11 @@ func (o *opHistory) historyUpdatePath(cfg *Config) {
 		if len(line) == 0 {
 			continue
 		}
-		o.Push([]rune(line))
+		entry, namespace := decodeHistoryLine(line)
+		if len(entry) == 0 {
+			continue
+		}
+		o.pushEntry([]rune(entry), namespace)
 		o.Compact()
 	}
 	if total > cfg.HistoryLimit {
@@ -86,16 +176,29 @@ func (o *opHistory) historyUpdatePath(cfg *Config) {
 	}
 	o.historyVer++
 	o.Push(nil)
-	return
+	return nil
 }
 
+// Compact removes the oldest entries, of any namespace, that exceed the
+// history limit.
 func (o *opHistory) Compact() {
-	cfg := o.operation.GetConfig()
-	for o.history.Len() > cfg.HistoryLimit && o.history.Len() > 0 {
+	limit := o.operation.GetConfig().HistoryLimit
+	for o.history.Len() > limit && o.history.Len() > 0 {
 		o.history.Remove(o.history.Front())
 	}
 }
 
+// namespace returns the namespace of the entries that are currently visible.
+func (o *opHistory) namespace() string {
+	return o.operation.GetConfig().HistoryNamespace
+}
+
+// isVisible returns whether elem belongs to the current namespace; the entry
+// being edited (the last one) is always visible.
+func (o *opHistory) isVisible(elem *list.Element) bool {
+	return elem.Value.(*hisItem).Namespace == o.namespace() || elem == o.history.Back()
+}
+
 func (o *opHistory) Rewrite() {
 	o.fdLock.Lock()
 	defer o.fdLock.Unlock()
@@ -116,7 +219,10 @@ func (o *opHistory) rewriteLocked() {
 
 	buf := bufio.NewWriter(fd)
 	for elem := o.history.Front(); elem != nil; elem = elem.Next() {
-		buf.WriteString(string(elem.Value.(*hisItem).Source) + "\n")
+		// skip the entry being edited, which is empty
+		if item := elem.Value.(*hisItem); len(item.Source) != 0 {
+			buf.WriteString(encodeHistoryLine(item))
+		}
 	}
 	buf.Flush()
 
@@ -141,52 +247,146 @@ func (o *opHistory) Close() {
 	}
 }
 
-func (o *opHistory) FindBck(isNewSearch bool, rs []rune, start int) (int, *list.Element) {
-	for elem := o.current; elem != nil; elem = elem.Prev() {
-		item := o.showItem(elem.Value)
-		if isNewSearch {
-			start += len(rs)
-		}
-		if elem == o.current {
-			if len(item) >= start {
-				item = item[:start]
-			}
-		}
-		idx := runes.IndexAllBckEx(item, rs, o.operation.GetConfig().HistorySearchFold)
-		if idx < 0 {
+// iterate calls f on each committed history entry of the current namespace,
+// starting from the most recent one, until f returns true. The entry currently
+// being edited (the last element of the list) is skipped.
+func (o *opHistory) iterate(f func(elem *list.Element) (stop bool)) {
+	back := o.history.Back()
+	if back == nil {
+		return
+	}
+	namespace := o.namespace()
+	for elem := back.Prev(); elem != nil; elem = elem.Prev() {
+		if elem.Value.(*hisItem).Namespace != namespace {
 			continue
 		}
-		return idx, elem
+		if f(elem) {
+			return
+		}
 	}
-	return -1, nil
 }
 
-func (o *opHistory) FindFwd(isNewSearch bool, rs []rune, start int) (int, *list.Element) {
-	for elem := o.current; elem != nil; elem = elem.Next() {
-		item := o.showItem(elem.Value)
-		if isNewSearch {
-			start -= len(rs)
-			if start < 0 {
-				start = 0
-			}
+// entries returns the committed history entries, most recent first.
+func (o *opHistory) entries() (result [][]rune) {
+	o.iterate(func(elem *list.Element) bool {
+		result = append(result, elem.Value.(*hisItem).Source)
+		return false
+	})
+	return
+}
+
+// Delete removes the committed entries for which f returns true (index
+// counts from the most recent entry, starting at 0), returning the number
+// of entries removed. The history file is rewritten if necessary.
+func (o *opHistory) Delete(f func(index int, entry []rune) bool) int {
+	var removed []*list.Element
+	index := 0
+	o.iterate(func(elem *list.Element) bool {
+		if f(index, elem.Value.(*hisItem).Source) {
+			removed = append(removed, elem)
 		}
+		index++
+		return false
+	})
+	if len(removed) == 0 {
+		return 0
+	}
+	for _, elem := range removed {
 		if elem == o.current {
-			if len(item)-1 >= start {
-				item = item[start:]
-			} else {
-				continue
-			}
+			o.current = o.history.Back()
+		}
+		if elem == o.pending {
+			o.pending = nil
+		}
+		o.history.Remove(elem)
+	}
+	o.historyVer++
+	o.Rewrite()
+	return len(removed)
+}
+
+// Import reads entries from r, one per line, and adds them to the history
+// (in the current namespace) as the most recent entries. The history file is rewritten if necessary.
+func (o *opHistory) Import(r io.Reader) error {
+	if !o.isEnabled() {
+		return nil
+	}
+	var imported [][]rune
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) != 0 {
+			imported = append(imported, []rune(decodeHistoryEntry(line)))
 		}
-		idx := runes.IndexAllEx(item, rs, o.operation.GetConfig().HistorySearchFold)
-		if idx < 0 {
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if o.history.Len() == 0 {
+		o.Push(nil)
+	}
+	back := o.history.Back()
+	for _, entry := range imported {
+		o.history.InsertBefore(&hisItem{Source: entry, Namespace: o.namespace()}, back)
+	}
+	o.Compact()
+	o.historyVer++
+	o.Rewrite()
+	return nil
+}
+
+// Merge adds entries (ordered oldest first) to the history as older than
+// the existing entries, skipping those already present; if an entry occurs
+// several times, only the most recent occurrence is kept. It returns the
+// number of entries added. The history file is rewritten if necessary.
+func (o *opHistory) Merge(entries [][]rune) int {
+	if !o.isEnabled() {
+		return 0
+	}
+	seen := make(map[string]struct{})
+	o.iterate(func(elem *list.Element) bool {
+		seen[string(elem.Value.(*hisItem).Source)] = struct{}{}
+		return false
+	})
+	if o.history.Len() == 0 {
+		o.Push(nil)
+	}
+	// insert from the most recent entry backwards, so that duplicates
+	// resolve to their most recent occurrence:
+	var inserted []*list.Element
+	mark := o.history.Front()
+	for i := len(entries) - 1; i >= 0; i-- {
+		if _, ok := seen[string(entries[i])]; ok || len(entries[i]) == 0 {
 			continue
 		}
-		if elem == o.current {
-			idx += start
+		seen[string(entries[i])] = struct{}{}
+		mark = o.history.InsertBefore(&hisItem{Source: runes.Copy(entries[i]), Namespace: o.namespace()}, mark)
+		inserted = append(inserted, mark)
+	}
+	o.Compact()
+	o.historyVer++
+	o.Rewrite()
+	// the oldest entries may have been removed by Compact, in which case
+	// they no longer have a successor:
+	added := 0
+	for _, elem := range inserted {
+		if elem.Next() != nil {
+			added++
 		}
-		return idx, elem
 	}
-	return -1, nil
+	return added
+}
+
+// Export writes the committed entries to w, oldest first, one per line
+// (multi-line entries are encoded as in the history file).
+func (o *opHistory) Export(w io.Writer) error {
+	entries := o.entries()
+	buf := bufio.NewWriter(w)
+	for i := len(entries) - 1; i >= 0; i-- {
+		buf.WriteString(encodeHistoryLine(&hisItem{Source: entries[i]}))
+	}
+	return buf.Flush()
 }
 
 func (o *opHistory) showItem(obj interface{}) []rune {
@@ -202,6 +402,9 @@ func (o *opHistory) Prev() []rune {
 		return nil
 	}
 	current := o.current.Prev()
+	for current != nil && !o.isVisible(current) {
+		current = current.Prev()
+	}
 	if current == nil {
 		return nil
 	}
@@ -214,6 +417,9 @@ func (o *opHistory) Next() ([]rune, bool) {
 		return nil, false
 	}
 	current := o.current.Next()
+	for current != nil && !o.isVisible(current) {
+		current = current.Next()
+	}
 	if current == nil {
 		return nil, false
 	}
@@ -222,6 +428,77 @@ func (o *opHistory) Next() ([]rune, bool) {
 	return runes.Copy(o.showItem(current.Value)), true
 }
 
+// PrevWithPrefix moves to the closest earlier entry that starts with prefix
+// and differs from line (the current contents of the buffer), returning
+// the entry and whether one was found.
+func (o *opHistory) PrevWithPrefix(prefix, line []rune) ([]rune, bool) {
+	if o.current == nil {
+		return nil, false
+	}
+	for elem := o.current.Prev(); elem != nil; elem = elem.Prev() {
+		item := o.showItem(elem.Value)
+		if o.isVisible(elem) && runes.HasPrefix(item, prefix) && !runes.Equal(item, line) {
+			o.current = elem
+			return runes.Copy(item), true
+		}
+	}
+	return nil, false
+}
+
+// NextWithPrefix is the counterpart of PrevWithPrefix that moves to later
+// entries; the entry being edited (the last one) always matches, so that
+// the original input can be restored.
+func (o *opHistory) NextWithPrefix(prefix, line []rune) ([]rune, bool) {
+	if o.current == nil {
+		return nil, false
+	}
+	back := o.history.Back()
+	for elem := o.current.Next(); elem != nil; elem = elem.Next() {
+		item := o.showItem(elem.Value)
+		if elem == back || (o.isVisible(elem) && runes.HasPrefix(item, prefix) && !runes.Equal(item, line)) {
+			o.current = elem
+			return runes.Copy(item), true
+		}
+	}
+	return nil, false
+}
+
+// Following returns the entry after the current one, or nil if the current
+// entry is the one being edited.
+func (o *opHistory) Following() *list.Element {
+	if o.current == nil || o.current == o.history.Back() {
+		return nil
+	}
+	return o.current.Next()
+}
+
+// SetPending arranges for elem to be loaded by TakePending at the start of
+// the next read cycle (this implements operate-and-get-next).
+func (o *opHistory) SetPending(elem *list.Element) {
+	if elem == o.history.Back() {
+		// nothing to load, the entry being edited is always empty
+		elem = nil
+	}
+	o.pending = elem
+}
+
+// TakePending makes the pending entry, if any, the current one and returns
+// its contents.
+func (o *opHistory) TakePending() ([]rune, bool) {
+	elem := o.pending
+	if elem == nil || !o.isEnabled() {
+		return nil, false
+	}
+	o.pending = nil
+	// an element removed from the list (e.g. by Compact) has no successor,
+	// whereas any other entry is followed at least by the one being edited:
+	if elem.Next() == nil {
+		return nil, false
+	}
+	o.current = elem
+	return runes.Copy(o.showItem(elem.Value)), true
+}
+
 // Disable the current history
 func (o *opHistory) Disable() {
 	o.enable = false
@@ -251,6 +528,9 @@ func (o *opHistory) New(current []rune) (err error) {
 	// just clean lastest history
 	if back := o.history.Back(); back != nil {
 		prev := back.Prev()
+		for prev != nil && !o.isVisible(prev) {
+			prev = prev.Prev()
+		}
 		if prev != nil {
 			if runes.Equal(current, prev.Value.(*hisItem).Source) {
 				o.current = o.history.Back()
@@ -285,6 +565,7 @@ func (o *opHistory) New(current []rune) (err error) {
 	// push a new one to commit current command
 	o.historyVer++
 	o.Push(nil)
+	o.Compact()
 	return
 }
 
@@ -310,20 +591,24 @@ func (o *opHistory) Update(s []rune, commit bool) (err error) {
 	r.Version = o.historyVer
 	if commit {
 		r.Source = s
+		r.Namespace = o.namespace()
 		if o.fd != nil {
 			// just report the error
-			_, err = o.fd.Write([]byte(string(r.Source) + "\n"))
+			_, err = o.fd.Write([]byte(encodeHistoryLine(r)))
 		}
 	} else {
 		r.Tmp = append(r.Tmp[:0], s...)
 	}
 	o.current.Value = r
-	o.Compact()
 	return
 }
 
 func (o *opHistory) Push(s []rune) {
+	o.pushEntry(s, o.namespace())
+}
+
+func (o *opHistory) pushEntry(s []rune, namespace string) {
 	s = runes.Copy(s)
-	elem := o.history.PushBack(&hisItem{Source: s})
+	elem := o.history.PushBack(&hisItem{Source: s, Namespace: namespace})
 	o.current = elem
 }
@@ -114,7 +114,7 @@ func Index(r rune, rs []rune) int {
 func ColorFilter(r []rune) []rune {
 	newr := make([]rune, 0, len(r))
 	for pos := 0; pos < len(r); pos++ {
-		if r[pos] == '\033' && r[pos+1] == '[' {
+		if r[pos] == '\033' && pos+1 < len(r) && r[pos+1] == '[' {
 			idx := Index('m', r[pos+2:])
 			if idx == -1 {
 				continue
@@ -239,6 +239,12 @@ func IsWordBreak(i rune) bool {
 // to decide if we generate an extra empty rune array to show next is new
 // line.
 func SplitByLine(prompt, rs []rune, offset, screenWidth, nextWidth int) [][]rune {
+	return SplitByLineCont(prompt, rs, offset, 0, screenWidth, nextWidth)
+}
+
+// SplitByLineCont is like SplitByLine, but each line that follows a newline
+// starts after a continuation prompt of width contWidth.
+func SplitByLineCont(prompt, rs []rune, offset, contWidth, screenWidth, nextWidth int) [][]rune {
 	ret := make([][]rune, 0)
 	prs := append(prompt, rs...)
 	si := 0
@@ -248,7 +254,8 @@ func SplitByLine(prompt, rs []rune, offset, screenWidth, nextWidth int) [][]rune
 		if r == '\n' {
 			ret = append(ret, prs[si:i+1])
 			si = i + 1
-			currentWidth = 0
+			currentWidth = contWidth
+			continue
 		} else if currentWidth+w > screenWidth {
 			ret = append(ret, prs[si:i])
 			si = i
@@ -262,3 +269,100 @@ func SplitByLine(prompt, rs []rune, offset, screenWidth, nextWidth int) [][]rune
 	}
 	return ret
 }
+
+const (
+	fuzzyScoreMatch       = 16
+	fuzzyBonusConsecutive = 8
+	fuzzyBonusBoundary    = 8
+	fuzzyBonusFirst       = 4
+	fuzzyPenaltyGap       = 1
+	fuzzyPenaltyLeading   = 1
+	fuzzyMaxLeading       = 8
+)
+
+// FuzzyMatch checks whether pattern is a subsequence of r. If it is, it returns
+// a score (higher is better) and the indices in r of the matched runes; matches
+// of consecutive runes, and of runes at the start of words, score higher.
+func FuzzyMatch(r, pattern []rune, fold bool) (score int, positions []int, ok bool) {
+	if len(pattern) == 0 {
+		return 0, nil, true
+	}
+	// find the first (leftmost) occurrence of the subsequence:
+	end := -1
+	pi := 0
+	for i := 0; i < len(r); i++ {
+		if EqualRune(r[i], pattern[pi], fold) {
+			pi++
+			if pi == len(pattern) {
+				end = i
+				break
+			}
+		}
+	}
+	if end == -1 {
+		return 0, nil, false
+	}
+	// then scan backwards from its end, which finds a shorter (tighter) match
+	positions = make([]int, len(pattern))
+	pi = len(pattern) - 1
+	for i := end; i >= 0; i-- {
+		if EqualRune(r[i], pattern[pi], fold) {
+			positions[pi] = i
+			pi--
+			if pi < 0 {
+				break
+			}
+		}
+	}
+
+	for i, pos := range positions {
+		score += fuzzyScoreMatch
+		if pos == 0 || (IsWordBreak(r[pos-1]) && !IsWordBreak(r[pos])) ||
+			(unicode.IsLower(r[pos-1]) && unicode.IsUpper(r[pos])) {
+			score += fuzzyBonusBoundary
+		}
+		if i == 0 {
+			if pos == 0 {
+				score += fuzzyBonusFirst
+			} else if pos < fuzzyMaxLeading {
+				score -= fuzzyPenaltyLeading * pos
+			} else {
+				score -= fuzzyPenaltyLeading * fuzzyMaxLeading
+			}
+		} else if positions[i-1] == pos-1 {
+			score += fuzzyBonusConsecutive
+		} else {
+			score -= fuzzyPenaltyGap * (pos - positions[i-1] - 1)
+		}
+	}
+	return score, positions, true
+}
+
+// EditDistance returns the Levenshtein distance between a and b: the
+// minimum number of runes to insert, delete or substitute to turn a into b.
+func EditDistance(a, b []rune, fold bool) int {
+	// prev and cur are the rows of the distance matrix for a[:i-1] and a[:i]
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] || (fold && unicode.ToLower(a[i-1]) == unicode.ToLower(b[j-1])) {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -44,6 +44,7 @@ func TestRuneWidth(t *testing.T) {
 		{[]rune("a"), 1},
 		{[]rune("你"), 2},
 		{ColorFilter([]rune("☭\033[13;1m你")), 3},
+		{ColorFilter([]rune("a\033")), 1},
 		{[]rune("漢字"), 4},     // kanji
 		{[]rune("ｶﾀｶﾅ"), 4},   // half-width katakana
 		{[]rune("カタカナ"), 8},   // full-width katakana
@@ -102,3 +103,64 @@ func TestAggRunes(t *testing.T) {
 		}
 	}
 }
+
+func TestFuzzyMatch(t *testing.T) {
+	type test struct {
+		text      string
+		pattern   string
+		fold      bool
+		ok        bool
+		positions []int
+	}
+
+	tests := []test{
+		{"git checkout", "gco", false, true, []int{0, 7, 9}},
+		{"git checkout", "GCO", false, false, nil},
+		{"git checkout", "GCO", true, true, []int{0, 7, 9}},
+		{"git commit", "gcm", false, true, []int{0, 4, 6}},
+		{"abcabc", "bc", false, true, []int{1, 2}},
+		{"a_b_c", "abcd", false, false, nil},
+		{"anything", "", false, true, nil},
+	}
+
+	for _, test := range tests {
+		_, positions, ok := FuzzyMatch([]rune(test.text), []rune(test.pattern), test.fold)
+		if ok != test.ok || !reflect.DeepEqual(positions, test.positions) {
+			t.Errorf("FuzzyMatch(%q, %q): expected %v %v, got %v %v", test.text, test.pattern, test.ok, test.positions, ok, positions)
+		}
+	}
+
+	better, _, _ := FuzzyMatch([]rune("git checkout"), []rune("check"), false)
+	worse, _, _ := FuzzyMatch([]rune("go help cmd --check"), []rune("check"), false)
+	if better <= worse {
+		t.Errorf("expected earlier match to score higher: %d <= %d", better, worse)
+	}
+	consecutive, _, _ := FuzzyMatch([]rune("make test"), []rune("test"), false)
+	scattered, _, _ := FuzzyMatch([]rune("tail -f errors.txt"), []rune("test"), false)
+	if consecutive <= scattered {
+		t.Errorf("expected consecutive match to score higher: %d <= %d", consecutive, scattered)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		fold     bool
+		distance int
+	}{
+		{"", "", false, 0},
+		{"help", "", false, 4},
+		{"", "help", false, 4},
+		{"help", "help", false, 0},
+		{"hlp", "help", false, 1},
+		{"stauts", "status", false, 2},
+		{"kitten", "sitting", false, 3},
+		{"HELP", "help", false, 4},
+		{"HELP", "help", true, 0},
+	}
+	for _, test := range tests {
+		if d := EditDistance([]rune(test.a), []rune(test.b), test.fold); d != test.distance {
+			t.Errorf("EditDistance(%q, %q): expected %d, got %d", test.a, test.b, test.distance, d)
+		}
+	}
+}
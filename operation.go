@@ -1,7 +1,9 @@
 package readline
 
 import (
+	"container/list"
 	"errors"
+	"fmt"
 	"io"
 	"sync"
 	"sync/atomic"
@@ -25,12 +27,21 @@ type operation struct {
 
 	history   *opHistory
 	search    *opSearch
+	fuzzy     *opFuzzySearch
 	completer *opCompleter
 	vim       *opVim
 	undo      *opUndo
+
+	yankArgIndex int // index in the history of the entry last used by yankLastArg
+	yankArgStart int // position in the buffer of the argument inserted by yankLastArg
 }
 
+// SetBuffer sets the initial contents of the buffer for the next read,
+// discarding the entry pending from operate-and-get-next.
 func (o *operation) SetBuffer(what string) {
+	o.m.Lock()
+	o.history.SetPending(nil)
+	o.m.Unlock()
 	o.buf.SetNoRefresh([]rune(what))
 }
 
@@ -69,6 +80,7 @@ func (o *operation) write(target io.Writer, b []byte) (int, error) {
 	})
 
 	o.search.RefreshIfNeeded()
+	o.fuzzy.RefreshIfNeeded()
 	if o.completer.IsInCompleteMode() {
 		o.completer.CompleteRefresh()
 	}
@@ -93,12 +105,14 @@ func (o *operation) GetConfig() *Config {
 }
 
 func (o *operation) readline(deadline chan struct{}) ([]rune, error) {
-	isTyping := false // don't add new undo entries during normal typing
+	isTyping := false         // don't add new undo entries during normal typing
+	isYankingArg := false     // whether the last command was yank-last-arg
+	isMenuCompleting := false // whether the last command was menu-complete
 
 	for {
 		keepInSearchMode := false
 		keepInCompleteMode := false
-		r, err := o.t.GetRune(deadline)
+		r, err := o.getRune(deadline)
 
 		if cfg := o.GetConfig(); cfg.FuncFilterInputRune != nil && err == nil {
 			var process bool
@@ -120,10 +134,26 @@ func (o *operation) readline(deadline chan struct{}) ([]rune, error) {
 				r = CharEnter
 			}
 		} else if err != nil {
+			if err == deadlineExceeded {
+				// abandon the line, as for an interrupt
+				o.buf.MoveToLineEnd()
+				o.buf.Refresh(nil)
+				o.buf.WriteString("\n")
+				o.buf.Reset()
+				o.history.Revert()
+			}
 			return nil, err
 		}
 		isUpdateHistory := true
 
+		if o.completer.IsInQueryMode() {
+			if err != io.EOF {
+				o.completer.HandleQuery(r)
+				continue
+			}
+			o.completer.exitQueryMode()
+		}
+
 		if o.completer.IsInCompleteSelectMode() {
 			keepInCompleteMode = o.completer.HandleCompleteSelect(r)
 			if keepInCompleteMode {
@@ -142,6 +172,17 @@ func (o *operation) readline(deadline chan struct{}) ([]rune, error) {
 			}
 		}
 
+		if o.fuzzy.IsSearchMode() {
+			if o.fuzzy.HandleSearchKey(r) {
+				continue
+			}
+
+			switch r {
+			case CharEnter, CharCtrlJ, CharInterrupt, CharBell:
+				continue
+			}
+		}
+
 		if o.vim.IsEnableVimMode() {
 			r = o.vim.HandleVim(r, func() rune {
 				r, err := o.t.GetRune(deadline)
@@ -156,9 +197,18 @@ func (o *operation) readline(deadline chan struct{}) ([]rune, error) {
 			}
 		}
 
+		if r == MetaMagicSpace {
+			if o.IsNormalMode() {
+				o.magicSpace()
+			}
+			r = ' '
+		}
+
 		var result []rune
 
 		isTypingRune := false
+		isYankArgRune := false
+		isMenuCompleteRune := false
 
 		switch r {
 		case CharBell:
@@ -171,6 +221,12 @@ func (o *operation) readline(deadline chan struct{}) ([]rune, error) {
 				o.buf.Refresh(nil)
 			}
 		case CharBckSearch:
+			if o.GetConfig().HistoryFuzzySearch {
+				if !o.fuzzy.SearchMode() {
+					o.t.Bell()
+				}
+				break
+			}
 			if !o.search.SearchMode(searchDirectionBackward) {
 				o.t.Bell()
 				break
@@ -185,11 +241,21 @@ func (o *operation) readline(deadline chan struct{}) ([]rune, error) {
 				break
 			}
 			keepInSearchMode = true
+		case MetaToggleRegex:
+			if o.search.IsSearchMode() {
+				o.search.ToggleRegex()
+				keepInSearchMode = true
+			}
 		case CharKill:
 			o.undo.add()
 			o.buf.Kill()
 			keepInCompleteMode = true
 		case MetaForward:
+			if o.buf.HasSuggestion() {
+				o.undo.add()
+				o.buf.AcceptSuggestion(true)
+				break
+			}
 			o.buf.MoveToNextWord()
 		case CharTranspose:
 			o.undo.add()
@@ -202,6 +268,11 @@ func (o *operation) readline(deadline chan struct{}) ([]rune, error) {
 		case CharLineStart:
 			o.buf.MoveToLineStart()
 		case CharLineEnd:
+			if o.buf.HasSuggestion() {
+				o.undo.add()
+				o.buf.AcceptSuggestion(false)
+				break
+			}
 			o.buf.MoveToLineEnd()
 		case CharBackspace, CharCtrlH:
 			o.undo.add()
@@ -229,13 +300,28 @@ func (o *operation) readline(deadline chan struct{}) ([]rune, error) {
 		case MetaBackspace, CharCtrlW:
 			o.undo.add()
 			o.buf.BackEscapeWord()
+		case MetaPageUp, MetaPageDown:
+			// no-op outside of complete select mode
 		case MetaShiftTab:
-			// no-op
+			if cfg := o.GetConfig(); cfg.AutoComplete != nil && cfg.CompletionStyle == CompletionMenu {
+				isMenuCompleteRune = true
+				if !o.completer.menuComplete(-1, isMenuCompleting) {
+					o.t.Bell()
+				}
+			}
 		case CharCtrlY:
 			o.buf.Yank()
+		case MetaYankLastArg:
+			o.undo.add()
+			ok := o.yankLastArg(isYankingArg)
+			if !ok {
+				o.t.Bell()
+			}
+			// after a failed repeat, the inserted argument can still be replaced
+			isYankArgRune = ok || isYankingArg
 		case CharCtrl_:
 			o.undo.undo()
-		case CharEnter, CharCtrlJ:
+		case CharEnter, CharCtrlJ, CharCtrlO:
 			if o.search.IsSearchMode() {
 				o.search.ExitSearchMode(false)
 			}
@@ -243,39 +329,66 @@ func (o *operation) readline(deadline chan struct{}) ([]rune, error) {
 				o.completer.ExitCompleteMode(true)
 				o.buf.Refresh(nil)
 			}
+			if complete := o.GetConfig().IsInputComplete; complete != nil && err != io.EOF && !complete(string(o.buf.Runes())) {
+				// continue the input on a new line
+				o.undo.add()
+				o.buf.WriteRune('\n')
+				o.buf.Refresh(nil) // to display the continuation prompt
+				break
+			}
+			if o.GetConfig().HistoryExpansion && !o.expandHistory() {
+				break
+			}
 			o.buf.MoveToLineEnd()
 			var data []rune
 			o.buf.WriteRune('\n')
 			data = o.buf.Reset()
 			data = data[:len(data)-1] // trim \n
 			result = data
+			var following *list.Element
+			if r == CharCtrlO {
+				// operate-and-get-next: load the next entry on the next call
+				following = o.history.Following()
+			}
 			if !o.GetConfig().DisableAutoSaveHistory {
 				// ignore IO error
 				_ = o.history.New(data)
 			} else {
 				isUpdateHistory = false
 			}
+			o.history.SetPending(following)
 			o.undo.init()
 		case CharBackward:
 			o.buf.MoveBackward()
 		case CharForward:
+			if o.buf.HasSuggestion() {
+				o.undo.add()
+				o.buf.AcceptSuggestion(false)
+				break
+			}
 			o.buf.MoveForward()
 		case CharPrev:
-			buf := o.history.Prev()
-			if buf != nil {
-				o.buf.Set(buf)
-				o.undo.init()
-			} else {
-				o.t.Bell()
+			if o.buf.MoveToPrevLine() {
+				break
+			}
+			if o.GetConfig().HistorySearchPrefix {
+				o.historySearchPrefix(true)
+				break
 			}
+			o.historyPrev()
 		case CharNext:
-			buf, ok := o.history.Next()
-			if ok {
-				o.buf.Set(buf)
-				o.undo.init()
-			} else {
-				o.t.Bell()
+			if o.buf.MoveToNextLine() {
+				break
 			}
+			if o.GetConfig().HistorySearchPrefix {
+				o.historySearchPrefix(false)
+				break
+			}
+			o.historyNext()
+		case MetaHistorySearchBackward:
+			o.historySearchPrefix(true)
+		case MetaHistorySearchForward:
+			o.historySearchPrefix(false)
 		case MetaDeleteKey, CharEOT:
 			o.undo.add()
 			// on Delete key or Ctrl-D, attempt to delete a character:
@@ -315,7 +428,15 @@ func (o *operation) readline(deadline chan struct{}) ([]rune, error) {
 			o.history.Revert()
 			return nil, ErrInterrupt
 		case CharTab:
-			if o.GetConfig().AutoComplete != nil {
+			if cfg := o.GetConfig(); cfg.AutoComplete != nil {
+				o.buf.SetSuggestion(nil)
+				if cfg.CompletionStyle == CompletionMenu {
+					isMenuCompleteRune = true
+					if !o.completer.menuComplete(1, isMenuCompleting) {
+						o.t.Bell()
+					}
+					break
+				}
 				if o.completer.OnComplete() {
 					if o.completer.IsInCompleteMode() {
 						keepInCompleteMode = true
@@ -350,6 +471,8 @@ func (o *operation) readline(deadline chan struct{}) ([]rune, error) {
 		}
 
 		isTyping = isTypingRune
+		isYankingArg = isYankArgRune
+		isMenuCompleting = isMenuCompleteRune
 
 		// suppress the Listener callback if we received Enter or similar and are
 		// submitting the result, since the buffer has already been cleared:
@@ -386,6 +509,175 @@ func (o *operation) readline(deadline chan struct{}) ([]rune, error) {
 		if result != nil {
 			return result, nil
 		}
+		o.updateSuggestion()
+	}
+}
+
+// getRune reads the next rune of input. While an asynchronous completion is
+// pending, it also displays its loading indicator or its candidates, and
+// cancels it when a key is pressed.
+func (o *operation) getRune(deadline chan struct{}) (rune, error) {
+	for o.completer.pending != nil {
+		wake, stop := o.completer.wake(deadline)
+		r, err := o.t.GetRune(wake)
+		stop()
+		if err == deadlineExceeded {
+			select {
+			case <-deadline:
+			default:
+				o.completer.handleWake()
+				continue
+			}
+		}
+		o.completer.cancelRequest()
+		return r, err
+	}
+	return o.t.GetRune(deadline)
+}
+
+// yankLastArg inserts the last word of the previous history entry at the
+// cursor; if repeat is true, the word inserted by the previous call is
+// replaced with the last word of the entry before that one.
+func (o *operation) yankLastArg(repeat bool) bool {
+	entries := o.history.entries()
+	index := 0
+	if repeat {
+		index = o.yankArgIndex + 1
+	}
+	for ; index < len(entries); index++ {
+		entry := entries[index]
+		words := splitWords(entry)
+		if len(words) == 0 {
+			continue
+		}
+		arg := entry[words[len(words)-1].start:words[len(words)-1].end]
+
+		line := o.buf.Runes()
+		start, end := o.buf.Pos(), o.buf.Pos()
+		if repeat {
+			start = o.yankArgStart
+		}
+		newLine := make([]rune, 0, len(line)-(end-start)+len(arg))
+		newLine = append(newLine, line[:start]...)
+		newLine = append(newLine, arg...)
+		newLine = append(newLine, line[end:]...)
+		o.buf.SetWithIdx(start+len(arg), newLine)
+		o.yankArgIndex, o.yankArgStart = index, start
+		return true
+	}
+	return false
+}
+
+// updateSuggestion computes the autosuggestion for the current line.
+func (o *operation) updateSuggestion() {
+	var suggestion []rune
+	cfg := o.GetConfig()
+	if cfg.AutoSuggest && !cfg.EnableMask && o.IsNormalMode() && o.buf.IsCursorInEnd() {
+		if line := o.buf.Runes(); len(line) != 0 {
+			if cfg.Suggester != nil {
+				suggestion = cfg.Suggester.Suggest(line, len(line))
+			} else {
+				o.m.Lock()
+				suggestion = o.history.suggest(line)
+				o.m.Unlock()
+			}
+		}
+	}
+
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.buf.SetSuggestion(suggestion) {
+		// redraw whatever was displayed below the line
+		o.search.RefreshIfNeeded()
+		o.fuzzy.RefreshIfNeeded()
+		if o.completer.IsInCompleteMode() {
+			o.completer.CompleteRefresh()
+		}
+	}
+}
+
+func (o *operation) historyPrev() {
+	buf := o.history.Prev()
+	if buf != nil {
+		o.buf.Set(buf)
+		o.undo.init()
+	} else {
+		o.t.Bell()
+	}
+}
+
+func (o *operation) historyNext() {
+	buf, ok := o.history.Next()
+	if ok {
+		o.buf.Set(buf)
+		o.undo.init()
+	} else {
+		o.t.Bell()
+	}
+}
+
+// historySearchPrefix implements GNU Readline's history-search-backward and
+// history-search-forward: it moves through the history entries that start
+// with the text before the cursor, leaving the cursor in place.
+func (o *operation) historySearchPrefix(backward bool) {
+	pos := o.buf.Pos()
+	if pos == 0 {
+		// empty prefix: same as ordinary history navigation
+		if backward {
+			o.historyPrev()
+		} else {
+			o.historyNext()
+		}
+		return
+	}
+	line := o.buf.Runes()
+	prefix := line[:pos]
+	var buf []rune
+	var ok bool
+	if backward {
+		buf, ok = o.history.PrevWithPrefix(prefix, line)
+	} else {
+		buf, ok = o.history.NextWithPrefix(prefix, line)
+	}
+	if !ok {
+		o.t.Bell()
+		return
+	}
+	if pos > len(buf) {
+		pos = len(buf)
+	}
+	o.buf.SetWithIdx(pos, buf)
+	o.undo.init()
+}
+
+// expandHistory performs history expansion on the buffer before it is
+// submitted. If expansion fails, it displays the error and returns false.
+func (o *operation) expandHistory() (ok bool) {
+	line := o.buf.Runes()
+	expanded, err := expandHistory(line, o.history.entries())
+	if err != nil {
+		fmt.Fprintf(o.Stderr(), "%v\n", err)
+		return false
+	}
+	if !runes.Equal(line, expanded) {
+		o.buf.Set(expanded)
+	}
+	return true
+}
+
+// magicSpace performs history expansion on the text before the cursor,
+// as in bash's magic-space (the space itself is inserted by the caller).
+func (o *operation) magicSpace() {
+	line := o.buf.Runes()
+	pos := o.buf.Pos()
+	expanded, err := expandHistory(line[:pos], o.history.entries())
+	if err != nil {
+		o.t.Bell()
+		return
+	}
+	if !runes.Equal(line[:pos], expanded) {
+		o.undo.add()
+		o.buf.SetWithIdx(len(expanded), append(expanded, line[pos:]...))
 	}
 }
 
@@ -403,6 +695,12 @@ func (o *operation) String() (string, error) {
 }
 
 func (o *operation) Runes() ([]rune, error) {
+	return o.runesWithDeadline(nil)
+}
+
+// runesWithDeadline is like Runes, but gives up reading the line when
+// deadline is closed, returning deadlineExceeded.
+func (o *operation) runesWithDeadline(deadline chan struct{}) ([]rune, error) {
 	o.t.EnterRawMode()
 	defer o.t.ExitRawMode()
 
@@ -420,6 +718,12 @@ func (o *operation) Runes() ([]rune, error) {
 	// may be existing text on the same line that ideally we don't
 	// want to overwrite and cause prompt to jump left.
 	o.getAndSetOffset(nil)
+	if !cfg.EnableMask {
+		// preload the entry chosen by operate-and-get-next
+		if line, ok := o.history.TakePending(); ok {
+			o.buf.SetNoRefresh(line)
+		}
+	}
 	o.buf.Print() // print prompt & buffer contents
 	// Prompt written safely, unlock until read completes and then
 	// lock again to unset.
@@ -430,13 +734,14 @@ func (o *operation) Runes() ([]rune, error) {
 	}
 
 	defer func() {
+		o.completer.cancelRequest()
 		o.m.Lock()
 		o.isPrompting = false
 		o.buf.SetOffset(cursorPosition{1, 1})
 		o.m.Unlock()
 	}()
 
-	return o.readline(nil)
+	return o.readline(deadline)
 }
 
 func (o *operation) getAndSetOffset(deadline chan struct{}) {
@@ -503,7 +808,7 @@ func (o *operation) Close() {
 }
 
 func (o *operation) IsNormalMode() bool {
-	return !o.completer.IsInCompleteMode() && !o.search.IsSearchMode()
+	return !o.completer.IsInCompleteMode() && !o.search.IsSearchMode() && !o.fuzzy.IsSearchMode()
 }
 
 func (op *operation) SetConfig(cfg *Config) (*Config, error) {
@@ -526,6 +831,9 @@ func (op *operation) SetConfig(cfg *Config) (*Config, error) {
 	if op.search == nil {
 		op.search = newOpSearch(op.buf.w, op.buf, op.history)
 	}
+	if op.fuzzy == nil {
+		op.fuzzy = newOpFuzzySearch(op.buf.w, op.buf, op.history)
+	}
 
 	if cfg.AutoComplete != nil && op.completer == nil {
 		op.completer = newOpCompleter(op.buf.w, op)
@@ -542,6 +850,41 @@ func (o *operation) SaveToHistory(content string) error {
 	return o.history.New([]rune(content))
 }
 
+func (o *operation) HistoryEntries() (result []string) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	for _, entry := range o.history.entries() {
+		result = append(result, string(entry))
+	}
+	return
+}
+
+func (o *operation) DeleteHistory(f func(index int, entry string) bool) int {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.history.Delete(func(index int, entry []rune) bool {
+		return f(index, string(entry))
+	})
+}
+
+func (o *operation) ReloadHistory() error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.history.Reload()
+}
+
+func (o *operation) ImportHistory(r io.Reader) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.history.Import(r)
+}
+
+func (o *operation) ExportHistory(w io.Writer) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.history.Export(w)
+}
+
 func (o *operation) Refresh() {
 	o.m.Lock()
 	defer o.m.Unlock()
@@ -1,15 +1,24 @@
 package readline
 
 import (
+	"fmt"
 	"io"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/ergochat/readline/internal/platform"
 )
 
+// Instance is a line editor reading from the configured terminal.
+//
+// The methods that modify the history (SaveToHistory, DeleteHistory,
+// DeleteHistoryFunc, ReloadHistory, ImportHistory and ImportShellHistory)
+// should be called between calls to ReadLine: a line being edited may have
+// been recalled from the history, and it is not updated when the history
+// changes.
 type Instance struct {
 	terminal  *terminal
 	operation *operation
@@ -21,6 +30,16 @@ type Instance struct {
 type Config struct {
 	// Prompt is the input prompt (ANSI escape sequences are supported on all platforms)
 	Prompt string
+	// ContinuationPrompt is displayed at the start of each line of the
+	// buffer after the first one, in multi-line input (see IsInputComplete),
+	// like the PS2 prompt of a shell.
+	ContinuationPrompt string
+	// IsInputComplete enables multi-line input: when Enter is pressed, it is
+	// called with the whole buffer, and if it returns false, a newline is
+	// inserted at the cursor instead of submitting the buffer. Up and Down
+	// move the cursor between the lines of the buffer, before moving
+	// through the history, and the whole buffer is a single history entry.
+	IsInputComplete func(text string) bool
 
 	// HistoryFile is the path to the file where persistent history will be stored
 	// (empty string disables).
@@ -29,12 +48,80 @@ type Config struct {
 	// or unset, the default value is 500; set to -1 to disable.
 	HistoryLimit           int
 	DisableAutoSaveHistory bool
+	// HistoryNamespace selects the namespace of the history that is navigated
+	// and searched, and to which submitted lines are added. Namespaces allow
+	// several prompts (e.g. via ReadLineWithConfig) to keep separate
+	// histories, while sharing one HistoryFile. HistoryLimit applies to all
+	// the namespaces together. The default namespace is the empty string.
+	HistoryNamespace string
 	// HistorySearchFold enables case-insensitive history searching.
 	HistorySearchFold bool
+	// HistorySearchSmartCase makes history searching case-insensitive unless
+	// the search pattern contains an uppercase letter.
+	HistorySearchSmartCase bool
+	// HistoryFuzzySearch replaces the incremental Ctrl+R search with an
+	// interactive fuzzy finder, which displays a ranked list of matching
+	// history entries below the prompt.
+	HistoryFuzzySearch bool
+	// HistorySearchPrefix makes the Up and Down arrow keys (as well as Ctrl+P
+	// and Ctrl+N) move only through the history entries that start with the
+	// text before the cursor, as in GNU Readline's history-search-backward
+	// and history-search-forward. The same behavior is available without
+	// this option, via MetaHistorySearchBackward and MetaHistorySearchForward.
+	HistorySearchPrefix bool
+	// HistoryExpansion enables bash-style history expansion (e.g. !!, !$,
+	// !-2:1, ^old^new) when a line is submitted. If an expansion fails, the
+	// error is displayed and the line remains available for editing.
+	// MetaMagicSpace can be bound to perform expansion while typing.
+	HistoryExpansion bool
 
 	// AutoComplete defines the tab-completion behavior. See the documentation for
 	// the AutoCompleter interface for details.
 	AutoComplete AutoCompleter
+	// CompletionStyle selects how the candidates for completion are
+	// presented; see the documentation for CompletionStyle for details.
+	CompletionStyle CompletionStyle
+	// CompletionSelectFilter makes typing while selecting a candidate in the
+	// list narrow the list to the candidates that contain the typed text
+	// (ignoring case), instead of ending the selection; Backspace widens it.
+	// The pages of the list are then turned with PageUp and PageDown only,
+	// rather than also with j and k.
+	CompletionSelectFilter bool
+	// CompletionQueryItems, if positive, is the number of candidates from
+	// which the user is first asked "Display all N possibilities? (y or n)",
+	// as with GNU Readline's completion-query-items. If they answer yes, the
+	// candidates are printed above the prompt by a pager that uses the whole
+	// height of the terminal: Space prints the next screen, Enter the next
+	// line, and q stops.
+	CompletionQueryItems int
+	// CompletionTimeout, if nonzero, is the maximum duration of a completion
+	// by a ContextCompleter: its context is then cancelled.
+	CompletionTimeout time.Duration
+	// CompletionLoadingDelay is how long a ContextCompleter can take before
+	// a loading indicator is displayed below the line. If it is 0 or unset,
+	// the default value is 200ms.
+	CompletionLoadingDelay time.Duration
+	// CandidateRenderer is an optional callback to customize how the
+	// candidates are displayed in the list, e.g. to color them or to change
+	// the highlighting of the selected candidate. If it is nil,
+	// DefaultCandidateRenderer is used.
+	CandidateRenderer CandidateRenderer
+	// DescribeCandidate is an optional callback returning a description of
+	// a candidate (e.g. the synopsis of a command): the description of the
+	// candidate selected in the list is displayed below the list, in up to
+	// 5 lines. If it is nil, and the AutoCompleter implements
+	// CandidateDescriber, its Describe method is used.
+	DescribeCandidate func(c Candidate) string
+
+	// AutoSuggest enables fish-style autosuggestions: while the cursor is at
+	// the end of the line, a suggestion for how to finish it is displayed in
+	// grey after the cursor. Right arrow or End accepts the whole suggestion,
+	// Alt-f accepts its first word.
+	AutoSuggest bool
+	// Suggester provides the autosuggestions; if it is nil, the most recent
+	// history entry that starts with the line is suggested.
+	// NewCompleterSuggester can be used to suggest from an AutoCompleter.
+	Suggester Suggester
 
 	// Listener is an optional callback to intercept keypresses.
 	Listener Listener
@@ -44,7 +131,8 @@ type Config struct {
 
 	// FuncFilterInputRune is an optional callback to translate keyboard inputs;
 	// it takes in the input rune and returns (translation, ok). If ok is false,
-	// the rune is skipped.
+	// the rune is skipped. It can also be used to bind keys to commands that
+	// have no default binding, e.g. MetaHistorySearchBackward.
 	FuncFilterInputRune func(rune) (rune, bool)
 
 	// VimMode enables Vim-style insert mode by default.
@@ -93,6 +181,9 @@ func (c *Config) init() error {
 	if c.HistoryLimit == 0 {
 		c.HistoryLimit = 500
 	}
+	if c.CompletionLoadingDelay == 0 {
+		c.CompletionLoadingDelay = 200 * time.Millisecond
+	}
 
 	if c.InterruptPrompt == "" {
 		c.InterruptPrompt = "^C"
@@ -124,6 +215,9 @@ func (c *Config) init() error {
 	if c.Painter == nil {
 		c.Painter = defaultPainter
 	}
+	if c.CandidateRenderer == nil {
+		c.CandidateRenderer = DefaultCandidateRenderer
+	}
 
 	c.isInteractive = c.FuncIsTerminal()
 
@@ -217,7 +311,8 @@ func (i *Instance) Readline() (string, error) {
 
 // SetDefault prefills a default value for the next call to Readline()
 // or related methods. The value will appear after the prompt for the user
-// to edit, with the cursor at the end of the line.
+// to edit, with the cursor at the end of the line. It takes precedence over
+// the history entry that Ctrl-O (operate-and-get-next) would otherwise load.
 func (i *Instance) SetDefault(defaultValue string) {
 	i.operation.SetBuffer(defaultValue)
 }
@@ -233,6 +328,71 @@ func (i *Instance) SaveToHistory(content string) error {
 	return i.operation.SaveToHistory(content)
 }
 
+// History returns the entries in the instance's history, most recent first.
+func (i *Instance) History() []string {
+	return i.operation.HistoryEntries()
+}
+
+// RangeHistory calls f on each entry in the instance's history, most recent
+// first, until f returns true. The index of the most recent entry is 0; the
+// same indices are accepted by DeleteHistory.
+func (i *Instance) RangeHistory(f func(index int, entry string) (stopIteration bool)) {
+	for index, entry := range i.operation.HistoryEntries() {
+		if f(index, entry) {
+			return
+		}
+	}
+}
+
+// DeleteHistory deletes the history entry with the given index, where 0 is
+// the most recent entry. If a history file is configured, it is rewritten.
+func (i *Instance) DeleteHistory(index int) error {
+	deleted := i.operation.DeleteHistory(func(entryIndex int, _ string) bool {
+		return entryIndex == index
+	})
+	if deleted == 0 {
+		return fmt.Errorf("history index %d out of range", index)
+	}
+	return nil
+}
+
+// DeleteHistoryFunc deletes the history entries for which f returns true,
+// returning the number of entries deleted. If a history file is configured,
+// it is rewritten.
+func (i *Instance) DeleteHistoryFunc(f func(entry string) bool) int {
+	return i.operation.DeleteHistory(func(_ int, entry string) bool {
+		return f(entry)
+	})
+}
+
+// ImportShellHistory reads the history file of a shell (bash, zsh or fish,
+// as specified by format) from r, and adds its commands to the history as
+// older than the existing entries. Commands already in the history are
+// skipped, and multi-line commands are imported as single entries, as with
+// IsInputComplete. It returns the number of entries added.
+func (i *Instance) ImportShellHistory(format ShellHistoryFormat, r io.Reader) (int, error) {
+	return i.operation.ImportShellHistory(format, r)
+}
+
+// ReloadHistory discards the in-memory history and reloads it from the
+// configured HistoryFile; it returns an error if HistoryFile is empty.
+func (i *Instance) ReloadHistory() error {
+	return i.operation.ReloadHistory()
+}
+
+// ExportHistory writes the instance's history to w, one entry per line, oldest
+// first. This is the same format used by HistoryFile.
+func (i *Instance) ExportHistory(w io.Writer) error {
+	return i.operation.ExportHistory(w)
+}
+
+// ImportHistory reads entries from r, in the format written by ExportHistory,
+// and adds them to the instance's history as its most recent entries.
+// If a history file is configured, it is rewritten.
+func (i *Instance) ImportHistory(r io.Reader) error {
+	return i.operation.ImportHistory(r)
+}
+
 // same as readline
 func (i *Instance) ReadSlice() ([]byte, error) {
 	return i.operation.Slice()
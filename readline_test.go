@@ -1,10 +1,58 @@
 package readline
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 )
 
+// newTestInstance creates an instance that reads the keypresses in input,
+// and otherwise behaves like an 80x24 terminal.
+func newTestInstance(t *testing.T, cfg *Config, input string) *Instance {
+	return newTestInstanceFromReader(t, cfg, strings.NewReader(input))
+}
+
+// newTestInstanceFromReader is like newTestInstance, but reads the keypresses
+// from stdin, e.g. to control when they arrive.
+func newTestInstanceFromReader(t *testing.T, cfg *Config, stdin io.Reader) *Instance {
+	cfg.Stdin = stdin
+	if cfg.Stdout == nil {
+		cfg.Stdout = io.Discard
+	}
+	if cfg.Stderr == nil {
+		cfg.Stderr = io.Discard
+	}
+	cfg.FuncIsTerminal = func() bool { return false }
+	cfg.FuncGetSize = func() (int, int) { return 80, 24 }
+	cfg.FuncMakeRaw = func() error { return nil }
+	cfg.FuncExitRaw = func() error { return nil }
+	cfg.FuncOnWidthChanged = func(func()) {}
+	rl, err := NewFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rl.Close() })
+	return rl
+}
+
+func assertReadLine(t *testing.T, rl *Instance, expected string) {
+	t.Helper()
+	line, err := rl.ReadLine()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if line != expected {
+		t.Fatalf("expected %q, got %q", expected, line)
+	}
+}
+
 func TestRace(t *testing.T) {
 	rl, err := NewFromConfig(&Config{})
 	if err != nil {
@@ -66,3 +114,663 @@ func TestParseCPRResponse(t *testing.T) {
 		}
 	}
 }
+
+func TestFuzzySearch(t *testing.T) {
+	// Ctrl+R, type a query, then Enter twice (once to select the match and
+	// once to submit it); then the same with Down to select the second match,
+	// and with PageDown and PageUp, which stop at the last and first matches
+	input := "\x12gco\r\r" + "\x12gco\x0e\r\r" +
+		"\x12gco\x1b[6~\x1b[6~\r\r" + "\x12gco\x1b[6~\x1b[5~\r\r" +
+		"\x12" + strings.Repeat("x", 100) + "\x07\r"
+	var output bytes.Buffer
+	rl := newTestInstance(t, &Config{HistoryFuzzySearch: true, Stdout: &output}, input)
+	for _, entry := range []string{"git checkout main", "go build", "git commit"} {
+		rl.SaveToHistory(entry)
+	}
+	assertReadLine(t, rl, "git commit")
+	assertReadLine(t, rl, "git checkout main")
+	assertReadLine(t, rl, "git checkout main")
+	assertReadLine(t, rl, "git commit")
+	// a long query is cut to the width of the terminal:
+	output.Reset()
+	assertReadLine(t, rl, "")
+	if !strings.Contains(output.String(), "fuzzy-search: "+strings.Repeat("x", 65)) ||
+		strings.Contains(output.String(), strings.Repeat("x", 66)) {
+		t.Fatalf("query line not truncated: %q", output.String())
+	}
+}
+
+func TestHistorySearchPrefix(t *testing.T) {
+	up, down := "\x1b[A", "\x1b[B"
+	input := "git " + up + up + "\r" +
+		"git " + up + up + down + "\r" +
+		"git c" + up + up + "\r" +
+		"x" + up + "\r"
+	rl := newTestInstance(t, &Config{HistorySearchPrefix: true}, input)
+	for _, entry := range []string{"git status", "ls", "git commit"} {
+		rl.SaveToHistory(entry)
+	}
+	assertReadLine(t, rl, "git status")
+	// the first Up now finds the line that was just submitted:
+	assertReadLine(t, rl, "git status")
+	// bell on the second Up, since "git commit" is the only match:
+	assertReadLine(t, rl, "git commit")
+	// no match, the input is unchanged:
+	assertReadLine(t, rl, "x")
+}
+
+func TestHistorySearch(t *testing.T) {
+	input := "\x12echo\r" +
+		"\x12foo\x12\x12\r" +
+		"\x12\x1brb.r\r" +
+		"\x12ECHO\x12\r"
+	var output bytes.Buffer
+	rl := newTestInstance(t, &Config{HistorySearchSmartCase: true, Stdout: &output}, input)
+	for _, entry := range []string{"echo foo foo", "ls", "ECHO bar"} {
+		rl.SaveToHistory(entry)
+	}
+	// smart case: a lowercase pattern matches uppercase text
+	assertReadLine(t, rl, "ECHO bar")
+	// repeated Ctrl-R cycles through both matches in the same entry:
+	assertReadLine(t, rl, "echo foo foo")
+	if !strings.Contains(output.String(), "bck-i-search (2/2): foo") {
+		t.Fatalf("match counter not displayed: %q", output.String())
+	}
+	assertReadLine(t, rl, "ECHO bar")
+	// an uppercase pattern is case-sensitive; the search fails on the second Ctrl-R:
+	output.Reset()
+	assertReadLine(t, rl, "ECHO bar")
+	if !strings.Contains(output.String(), "failing bck-i-search (1/1): ECHO") {
+		t.Fatalf("failing search not displayed: %q", output.String())
+	}
+}
+
+func TestAutoSuggest(t *testing.T) {
+	input := "git c\x1bf\r" + "git s\x1b[C\r" + "git s\r"
+	var typed []string
+	cfg := &Config{
+		AutoSuggest: true,
+		Listener: func(line []rune, pos int, key rune) ([]rune, int, bool) {
+			if key == 'c' || key == 's' {
+				typed = append(typed, string(line))
+			}
+			return nil, 0, false
+		},
+	}
+	rl := newTestInstance(t, cfg, input)
+	for _, entry := range []string{"git status", "git commit -m fix"} {
+		rl.SaveToHistory(entry)
+	}
+	// Alt-f accepts one word of the suggestion:
+	assertReadLine(t, rl, "git commit")
+	// Right arrow accepts the whole suggestion:
+	assertReadLine(t, rl, "git status")
+	// the suggestion is not part of the submitted line:
+	assertReadLine(t, rl, "git s")
+	if !reflect.DeepEqual(typed, []string{"git c", "git s", "git s"}) {
+		t.Fatalf("suggestion passed to the Listener: %q", typed)
+	}
+
+	completer := NewPrefixCompleter(PcItem("hello"), PcItem("help"))
+	rl = newTestInstance(t, &Config{AutoSuggest: true, Suggester: NewCompleterSuggester(completer)}, "h\x05\r"+"hell\x05\r")
+	assertReadLine(t, rl, "hel")
+	assertReadLine(t, rl, "hello ")
+}
+
+func TestYankLastArg(t *testing.T) {
+	input := "vi \x1b.\r" + "x \x1b.\x1b_\x1b.\r" + "y \x1b.\x1b.\x1b.\x1b.\x1b.\r"
+	rl := newTestInstance(t, &Config{}, input)
+	for _, entry := range []string{"ls /tmp", "cp a.txt 'b c.txt'"} {
+		rl.SaveToHistory(entry)
+	}
+	assertReadLine(t, rl, "vi 'b c.txt'")
+	// repeated presses walk back through the history:
+	assertReadLine(t, rl, "x /tmp")
+	// past the oldest entry, the last argument found is kept:
+	assertReadLine(t, rl, "y /tmp")
+}
+
+func TestOperateAndGetNext(t *testing.T) {
+	up := "\x1b[A"
+	input := up + up + up + "\x0f" + "\x0f" + "\r" + "z\r"
+	rl := newTestInstance(t, &Config{}, input)
+	for _, entry := range []string{"a1", "a2", "a3"} {
+		rl.SaveToHistory(entry)
+	}
+	assertReadLine(t, rl, "a1")
+	// each call starts with the entry following the one submitted with Ctrl-O:
+	assertReadLine(t, rl, "a2")
+	assertReadLine(t, rl, "a3")
+	assertReadLine(t, rl, "z")
+
+	// a default value takes precedence over the pending entry:
+	rl = newTestInstance(t, &Config{}, up+up+"\x0f"+"\r")
+	for _, entry := range []string{"a1", "a2", "a3"} {
+		rl.SaveToHistory(entry)
+	}
+	assertReadLine(t, rl, "a2")
+	if line, err := rl.ReadLineWithDefault("default"); err != nil || line != "default" {
+		t.Fatalf("expected the default value, got %q (%v)", line, err)
+	}
+}
+
+func TestHistoryNamespace(t *testing.T) {
+	historyFile := filepath.Join(t.TempDir(), "history")
+	up := "\x1b[A"
+	input := "select 1\r" + up + "\r" + up + "\r"
+	rl := newTestInstance(t, &Config{HistoryFile: historyFile}, input)
+	rl.SaveToHistory("ls")
+	sqlConfig := *rl.GetConfig()
+	sqlConfig.HistoryNamespace = "sql"
+
+	for _, expected := range []string{"select 1", "select 1"} {
+		line, err := rl.ReadLineWithConfig(&sqlConfig)
+		if err != nil || line != expected {
+			t.Fatalf("expected %q, got %q (%v)", expected, line, err)
+		}
+	}
+	assertReadLine(t, rl, "ls")
+	rl.Close()
+
+	contents, err := os.ReadFile(historyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "ls\n\x1fsql\x1fselect 1\n" {
+		t.Fatalf("unexpected history file contents: %q", contents)
+	}
+	rl = newTestInstance(t, &Config{HistoryFile: historyFile, HistoryNamespace: "sql"}, "")
+	if history := rl.History(); !reflect.DeepEqual(history, []string{"select 1"}) {
+		t.Fatalf("unexpected history: %v", history)
+	}
+
+	// rewriting the file does not store the entry being edited:
+	rl.SaveToHistory("select 2")
+	if err := rl.DeleteHistory(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.ReloadHistory(); err != nil {
+		t.Fatal(err)
+	}
+	if history := rl.History(); !reflect.DeepEqual(history, []string{"select 1"}) {
+		t.Fatalf("unexpected history after rewrite: %#v", history)
+	}
+	// HistoryLimit applies to all the namespaces together (the entry being
+	// edited counts too):
+	rl = newTestInstance(t, &Config{HistoryLimit: 3}, "select 1\r"+"select 2\r")
+	rl.SaveToHistory("ls")
+	sqlConfig = *rl.GetConfig()
+	sqlConfig.HistoryNamespace = "sql"
+	for _, expected := range []string{"select 1", "select 2"} {
+		line, err := rl.ReadLineWithConfig(&sqlConfig)
+		if err != nil || line != expected {
+			t.Fatalf("expected %q, got %q (%v)", expected, line, err)
+		}
+	}
+	if history := rl.History(); len(history) != 0 {
+		t.Fatalf("unexpected history in the default namespace: %#v", history)
+	}
+	rl.SetConfig(&sqlConfig)
+	if history := rl.History(); !reflect.DeepEqual(history, []string{"select 2", "select 1"}) {
+		t.Fatalf("unexpected history in the sql namespace: %#v", history)
+	}
+}
+
+func TestRichCompleter(t *testing.T) {
+	candidates := []Candidate{
+		{Text: "--verbose", Description: "enable verbose logging", Group: "flags", Suffix: " "},
+		{Text: "./src/main.go", Display: "main.go", Group: "files", Suffix: " "},
+		{Text: "--version", Description: "print the version", Group: "flags", Suffix: " "},
+	}
+	completer := RichCompleterFunc(func(line []rune, pos int) (c Completions) {
+		c.Offset = pos
+		if pos == 0 {
+			// all the candidates match; don't copy them
+			c.Candidates = candidates
+			return
+		}
+		for _, candidate := range candidates {
+			if strings.HasPrefix(candidate.Text, string(line[:pos])) {
+				c.Candidates = append(c.Candidates, candidate)
+			}
+		}
+		return
+	})
+	var output bytes.Buffer
+	input := "--verb\t\r" + "--ver\t\r" + "\t\t\t\r"
+	rl := newTestInstance(t, &Config{AutoComplete: completer, Stdout: &output}, input)
+	assertReadLine(t, rl, "--verbose ")
+	assertReadLine(t, rl, "--ver")
+	for _, expected := range []string{"\033[1mflags\033[0m", "--verbose  enable verbose logging", "--version  print the version"} {
+		if !strings.Contains(output.String(), expected) {
+			t.Fatalf("%q not displayed: %q", expected, output.String())
+		}
+	}
+	output.Reset()
+	// groups are listed in order of first appearance:
+	assertReadLine(t, rl, "--version ")
+	if !regexp.MustCompile(`(?s)flags.*--verbose.*--version.*files.*main\.go`).MatchString(output.String()) {
+		t.Fatalf("unexpected candidate list: %q", output.String())
+	}
+	// the completer's candidates are not sorted in place:
+	if candidates[1].Group != "files" {
+		t.Fatalf("candidates were reordered: %#v", candidates)
+	}
+
+	// the adapter for AutoCompleter:
+	newLines, length := completer.Do([]rune("--v"), 3)
+	if !reflect.DeepEqual(newLines, [][]rune{[]rune("erbose "), []rune("ersion ")}) || length != 3 {
+		t.Fatalf("unexpected result of Do: %q, %d", newLines, length)
+	}
+}
+
+func TestCompletionStyle(t *testing.T) {
+	completer := RichCompleterFunc(func(line []rune, pos int) (c Completions) {
+		for _, word := range []string{"gitea", "github", "gitlab"} {
+			if strings.HasPrefix(word, string(line[:pos])) {
+				c.Candidates = append(c.Candidates, Candidate{Text: word, Suffix: " "})
+			}
+		}
+		c.Offset = pos
+		return
+	})
+	testCases := []struct {
+		style    CompletionStyle
+		input    string
+		expected []string
+	}{
+		{CompletionMenu, "g\t\r" + "g\t\t\r" + "g\t\t\t\t\r" + "g\x1b[Z\r" + "g\t\x1b[Z\x1b[Z\r",
+			[]string{"gitea", "github", "g", "gitlab", "gitlab"}},
+		// other keys end the cycle; a single candidate is completed with its suffix:
+		{CompletionMenu, "g\t\tx\r" + "gith\t\r" + "x\t\r" + "g\t\x02\x06\t\r",
+			[]string{"githubx", "github ", "x", "gitea "}},
+		{CompletionMenuSelect, "g\t\r\r" + "g\t\t\x07\r" + "g\t\t\x1b[Z\x1b[Z\r\r" + "g\t\tx\r",
+			[]string{"gitea ", "g", "gitlab ", "githubx"}},
+	}
+	for _, tc := range testCases {
+		rl := newTestInstance(t, &Config{AutoComplete: completer, CompletionStyle: tc.style}, tc.input)
+		for _, expected := range tc.expected {
+			assertReadLine(t, rl, expected)
+		}
+	}
+}
+
+// describingCompleter is a RichCompleter that also implements
+// CandidateDescriber.
+type describingCompleter struct {
+	RichCompleterFunc
+}
+
+func (d describingCompleter) Describe(c Candidate) string {
+	if c.Text == "cherry" {
+		return ""
+	}
+	return "fruit: " + c.Text + "\nsecond line\n"
+}
+
+func TestCandidateDescriber(t *testing.T) {
+	completer := describingCompleter{RichCompleterFunc(func(line []rune, pos int) (c Completions) {
+		for _, word := range []string{"apple", "banana", "cherry"} {
+			c.Candidates = append(c.Candidates, Candidate{Text: word})
+		}
+		return
+	})}
+	var output bytes.Buffer
+	rl := newTestInstance(t, &Config{AutoComplete: completer, Stdout: &output}, "\t\t\t\r\r"+"\t\t\t\t\r\r")
+	assertReadLine(t, rl, "banana")
+	for _, expected := range []string{"\nfruit: apple\nsecond line\x1b[", "\nfruit: banana\nsecond line\x1b["} {
+		if !strings.Contains(output.String(), expected) {
+			t.Fatalf("%q not displayed: %q", expected, output.String())
+		}
+	}
+	output.Reset()
+	// nothing is displayed below the list if the description is empty:
+	assertReadLine(t, rl, "cherry")
+	if !regexp.MustCompile(`\x1b\[30;47mcherry *\x1b\[0m\x1b\[1A`).MatchString(output.String()) {
+		t.Fatalf("unexpected output: %q", output.String())
+	}
+
+	// Config.DescribeCandidate does not require a CandidateDescriber:
+	output.Reset()
+	rl = newTestInstance(t, &Config{
+		AutoComplete:      completer.RichCompleterFunc,
+		DescribeCandidate: func(c Candidate) string { return "config: " + c.Text },
+		Stdout:            &output,
+	}, "\t\t\r\r")
+	assertReadLine(t, rl, "apple")
+	if !strings.Contains(output.String(), "\nconfig: apple\x1b[") {
+		t.Fatalf("description not displayed: %q", output.String())
+	}
+}
+
+func TestFilterableCompletions(t *testing.T) {
+	for _, filterable := range []bool{false, true} {
+		calls := 0
+		completer := RichCompleterFunc(func(line []rune, pos int) (c Completions) {
+			calls++
+			start, _ := wordStart(line, pos)
+			for _, word := range []string{"apple", "apricot", "avocado", "banana"} {
+				if strings.HasPrefix(word, string(line[start:pos])) {
+					c.Candidates = append(c.Candidates, Candidate{Text: word, Suffix: " "})
+				}
+			}
+			c.Offset = pos - start
+			c.Filterable = filterable
+			return
+		})
+		rl := newTestInstance(t, &Config{AutoComplete: completer}, "\tap\t\r\r"+"\ta b\r")
+		assertReadLine(t, rl, "apple ")
+		if expected := map[bool]int{false: 3, true: 1}[filterable]; calls != expected {
+			t.Fatalf("expected %d calls with Filterable %v, got %d", expected, filterable, calls)
+		}
+		// a space starts another word:
+		calls = 0
+		assertReadLine(t, rl, "a b")
+		if expected := map[bool]int{false: 3, true: 2}[filterable]; calls != expected {
+			t.Fatalf("expected %d calls with Filterable %v, got %d", expected, filterable, calls)
+		}
+	}
+}
+
+func TestCompletionQueryItems(t *testing.T) {
+	var words []string
+	for i := 0; i < 300; i++ {
+		words = append(words, fmt.Sprintf("item%03d", i))
+	}
+	completer := RichCompleterFunc(func(line []rune, pos int) (c Completions) {
+		for _, word := range words {
+			if strings.HasPrefix(word, string(line[:pos])) {
+				c.Candidates = append(c.Candidates, Candidate{Text: word, Suffix: " "})
+			}
+		}
+		c.Offset = pos
+		return
+	})
+	var output bytes.Buffer
+	// the candidates are laid out in 9 columns, and the pager prints 22
+	// lines at once (24, minus the line and the pager's prompt):
+	input := "\t\txn\r" + "\t\ty \r" + "\t\ty\rq\r" + "item10\t\t\r\r" + "\t\t"
+	rl := newTestInstance(t, &Config{AutoComplete: completer, CompletionQueryItems: 100, Stdout: &output}, input)
+
+	// other keys than y and n ring the bell (as does the first Tab, which
+	// inserts the common prefix):
+	assertReadLine(t, rl, "item")
+	if !strings.Contains(output.String(), "Display all 300 possibilities? (y or n)") || strings.Count(output.String(), "\a") != 2 ||
+		strings.Contains(output.String(), "item000") {
+		t.Fatalf("unexpected output: %q", output.String())
+	}
+
+	output.Reset()
+	assertReadLine(t, rl, "item")
+	if !strings.Contains(output.String(), "item000 item001 ") || !strings.Contains(output.String(), "item297 item298 item299 \n") ||
+		strings.Count(output.String(), "--More--") != 1 {
+		t.Fatalf("unexpected output: %q", output.String())
+	}
+
+	// Enter prints the next line:
+	output.Reset()
+	assertReadLine(t, rl, "item")
+	if !strings.Contains(output.String(), "item206 \n") || strings.Contains(output.String(), "item207") {
+		t.Fatalf("unexpected output: %q", output.String())
+	}
+
+	// below the threshold, the candidates are listed as usual:
+	output.Reset()
+	assertReadLine(t, rl, "item100 ")
+	if strings.Contains(output.String(), "possibilities") {
+		t.Fatalf("unexpected output: %q", output.String())
+	}
+
+	// the end of the input ends the question:
+	assertReadLine(t, rl, "item")
+}
+
+func TestCandidateRenderer(t *testing.T) {
+	completer := RichCompleterFunc(func(line []rune, pos int) Completions {
+		return Completions{Candidates: []Candidate{{Text: "apple", Style: "\033[34m"}, {Text: "banana"}}}
+	})
+	// the escape sequences do not count in the width of the columns:
+	var output bytes.Buffer
+	rl := newTestInstance(t, &Config{AutoComplete: completer, Stdout: &output}, "\t\t\r")
+	assertReadLine(t, rl, "apple")
+	for _, expected := range []string{"\033[34mapple  \033[0mbanana ", "\033[30;47mapple  \033[0mbanana "} {
+		if !strings.Contains(output.String(), expected) {
+			t.Fatalf("%q not displayed: %q", expected, output.String())
+		}
+	}
+
+	renderer := func(c *Candidate, selected bool) string {
+		if selected {
+			return "> " + c.Text
+		}
+		return "  " + c.Text
+	}
+	output.Reset()
+	rl = newTestInstance(t, &Config{AutoComplete: completer, CandidateRenderer: renderer, Stdout: &output}, "\t\t\r")
+	assertReadLine(t, rl, "apple")
+	for _, expected := range []string{"  apple    banana ", "> apple    banana "} {
+		if !strings.Contains(output.String(), expected) {
+			t.Fatalf("%q not displayed: %q", expected, output.String())
+		}
+	}
+}
+
+func TestCompleteWordUnderCursor(t *testing.T) {
+	completer := NewPrefixCompleter(
+		PcItem("git", PcItem("checkout"), PcItem("cherry-pick"), PcItem("commit")),
+	)
+	testCases := []struct {
+		style    CompletionStyle
+		input    string
+		expected []string
+	}{
+		// the runes after the cursor are replaced along with the word:
+		// (but the common prefix of the candidates is not inserted):
+		{CompletionList, "git checkuot\x02\x02\x02\t\r" + "git cox\x02\t\r" + "git chXY\x02\x02\t\t\r\r" + "git chec\x02\x02\x02\t\r",
+			[]string{"git checkout ", "git commit ", "git checkout ", "git chec"}},
+		{CompletionMenu, "git chXY\x02\x02\t\t\r" + "git chXY\x02\x02\t\t\t\r",
+			[]string{"git cherry-pick ", "git chXY"}},
+		{CompletionMenuSelect, "git chXY\x02\x02\t\r\r" + "git chXY\x02\x02\t\x07\r",
+			[]string{"git checkout ", "git chXY"}},
+	}
+	for _, tc := range testCases {
+		rl := newTestInstance(t, &Config{AutoComplete: completer, CompletionStyle: tc.style}, tc.input)
+		for _, expected := range tc.expected {
+			assertReadLine(t, rl, expected)
+		}
+	}
+}
+
+func TestCompletionSelectFilter(t *testing.T) {
+	words := []string{"apple", "apricot", "banana", "blueberry", "cherry"}
+	for i := 0; i < 300; i++ {
+		words = append(words, fmt.Sprintf("item%03d", i))
+	}
+	completer := RichCompleterFunc(func(line []rune, pos int) (c Completions) {
+		for _, word := range words {
+			if strings.HasPrefix(word, string(line[:pos])) {
+				c.Candidates = append(c.Candidates, Candidate{Text: word, Suffix: " "})
+			}
+		}
+		c.Offset = pos
+		return
+	})
+	var output bytes.Buffer
+	input := "\t\tan\r\r" + "\t\trr\x7f\t\r\r" + "\t\tz\r\r" + "i\t\t\t\x1b[6~\r\r" + "i\t\t\t\x1b[6~\x1b[5~\r\r"
+	rl := newTestInstance(t, &Config{AutoComplete: completer, CompletionSelectFilter: true, Stdout: &output}, input)
+	assertReadLine(t, rl, "banana ")
+	assertReadLine(t, rl, "blueberry ")
+	if !strings.Contains(output.String(), "-- filter: rr --") {
+		t.Fatalf("filter not displayed: %q", output.String())
+	}
+	// no candidate contains "z":
+	assertReadLine(t, rl, "apple ")
+	// PageDown selects the first candidate of the next page:
+	if line, err := rl.ReadLine(); err != nil || !strings.HasPrefix(line, "item") || line == "item000 " {
+		t.Fatalf("unexpected line after PageDown: %q, %v", line, err)
+	}
+	assertReadLine(t, rl, "item000 ")
+	if !strings.Contains(output.String(), "(PgUp: prev page) (PgDn: next page)") {
+		t.Fatalf("guidance not displayed: %q", output.String())
+	}
+}
+
+func TestHistoryExpansionOnSubmit(t *testing.T) {
+	input := "cd !$\r" + "!nonexistent\r\x15ok\r" + "ls !$ \r"
+	cfg := &Config{
+		HistoryExpansion: true,
+		FuncFilterInputRune: func(r rune) (rune, bool) {
+			if r == ' ' {
+				return MetaMagicSpace, true
+			}
+			return r, true
+		},
+	}
+	rl := newTestInstance(t, cfg, input)
+	rl.SaveToHistory("ls -la /tmp")
+	assertReadLine(t, rl, "cd /tmp")
+	// the failed expansion leaves the line in the buffer, where it is erased:
+	assertReadLine(t, rl, "ok")
+	assertReadLine(t, rl, "ls ok ")
+}
+
+func TestHistoryAPI(t *testing.T) {
+	historyFile := filepath.Join(t.TempDir(), "history")
+	rl := newTestInstance(t, &Config{HistoryFile: historyFile}, "")
+	for _, entry := range []string{"ls", "git status", "git commit", "make"} {
+		rl.SaveToHistory(entry)
+	}
+	assertHistory := func(expected ...string) {
+		t.Helper()
+		if history := rl.History(); !reflect.DeepEqual(history, expected) {
+			t.Fatalf("expected history %#v, got %#v", expected, history)
+		}
+	}
+	assertHistory("make", "git commit", "git status", "ls")
+
+	if err := rl.DeleteHistory(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.DeleteHistory(3); err == nil {
+		t.Fatal("expected an error deleting a nonexistent entry")
+	}
+	assertHistory("make", "git status", "ls")
+	if n := rl.DeleteHistoryFunc(func(entry string) bool { return strings.HasPrefix(entry, "git ") }); n != 1 {
+		t.Fatalf("expected to delete 1 entry, deleted %d", n)
+	}
+	assertHistory("make", "ls")
+
+	// deletions are persisted to the history file:
+	if err := rl.ReloadHistory(); err != nil {
+		t.Fatal(err)
+	}
+	assertHistory("make", "ls")
+
+	if err := rl.ImportHistory(strings.NewReader("vim\n\ngo test\n")); err != nil {
+		t.Fatal(err)
+	}
+	assertHistory("go test", "vim", "make", "ls")
+	var exported strings.Builder
+	if err := rl.ExportHistory(&exported); err != nil {
+		t.Fatal(err)
+	}
+	if exported.String() != "ls\nmake\nvim\ngo test\n" {
+		t.Fatalf("unexpected export %q", exported.String())
+	}
+
+	var visited []string
+	rl.RangeHistory(func(index int, entry string) bool {
+		visited = append(visited, fmt.Sprintf("%d %s", index, entry))
+		return index == 1
+	})
+	if !reflect.DeepEqual(visited, []string{"0 go test", "1 vim"}) {
+		t.Fatalf("unexpected iteration %#v", visited)
+	}
+
+	// the history is unchanged if the file cannot be reopened:
+	if err := os.RemoveAll(filepath.Dir(historyFile)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.ReloadHistory(); err == nil {
+		t.Fatal("expected an error reloading a missing history file")
+	}
+	assertHistory("go test", "vim", "make", "ls")
+
+	// there is nothing to reload without a history file:
+	rl = newTestInstance(t, &Config{}, "")
+	if err := rl.ReloadHistory(); err == nil {
+		t.Fatal("expected an error reloading without a history file")
+	}
+}
+
+func TestMultilineInput(t *testing.T) {
+	historyFile := filepath.Join(t.TempDir(), "history")
+	cfg := &Config{
+		ContinuationPrompt: ". ",
+		IsInputComplete:    func(text string) bool { return strings.HasSuffix(text, ";") },
+		HistoryFile:        historyFile,
+	}
+	// Up and Down (Ctrl-P and Ctrl-N) move between the lines, keeping the
+	// column if possible, and then through the history:
+	input := "select\r  *\x10\x10X\x0e\x0e;\r" + "\x10\x10\x10\r" + "ab\rc;\r"
+	rl := newTestInstance(t, cfg, input)
+	assertReadLine(t, rl, "selXect\n  *;")
+	assertReadLine(t, rl, "selXect\n  *;")
+	assertReadLine(t, rl, "ab\nc;")
+
+	// multi-line entries are stored in the history file as single entries:
+	if err := rl.ReloadHistory(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"ab\nc;", "selXect\n  *;"}
+	if history := rl.History(); !reflect.DeepEqual(history, expected) {
+		t.Fatalf("expected history %#v, got %#v", expected, history)
+	}
+	var exported strings.Builder
+	if err := rl.ExportHistory(&exported); err != nil {
+		t.Fatal(err)
+	}
+	if exported.String() != "\x1eselXect\\n  *;\n\x1eab\\nc;\n" {
+		t.Fatalf("unexpected export %q", exported.String())
+	}
+	// backslashes in multi-line entries are escaped too:
+	rl.SaveToHistory("echo a\\\nb\\n")
+	if err := rl.ReloadHistory(); err != nil {
+		t.Fatal(err)
+	}
+	if history := rl.History(); history[0] != "echo a\\\nb\\n" {
+		t.Fatalf("unexpected history after reload: %#v", history)
+	}
+	if err := rl.ImportHistory(strings.NewReader("\x1eecho c\\\\\\nd\n")); err != nil {
+		t.Fatal(err)
+	}
+	if history := rl.History(); history[0] != "echo c\\\nd" {
+		t.Fatalf("unexpected history after import: %#v", history)
+	}
+}
+
+func TestMultilineSuggestion(t *testing.T) {
+	rl := newTestInstance(t, &Config{Prompt: "> ", ContinuationPrompt: ". ", AutoSuggest: true}, "")
+	buf := rl.operation.buf
+	buf.SetNoRefresh([]rune("sel"))
+	buf.SetSuggestion([]rune("ect\n  *;"))
+	// the lines of a multi-line suggestion start with the continuation
+	// prompt too, and the cursor goes back to the end of the buffer:
+	if output := string(buf.output()); !strings.HasSuffix(output, "\033[90mect\n\033[0m. \033[90m  *;\033[0m\033[1A\033[6G") {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+func TestHistoryFileCompatibility(t *testing.T) {
+	// a history file written before multi-line entries were supported is
+	// read one entry per line, including entries ending with a backslash:
+	historyFile := filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(historyFile, []byte("cd C:\\\nls\necho a\\nb\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rl := newTestInstance(t, &Config{HistoryFile: historyFile}, "")
+	expected := []string{"echo a\\nb", "ls", "cd C:\\"}
+	if history := rl.History(); !reflect.DeepEqual(history, expected) {
+		t.Fatalf("expected history %#v, got %#v", expected, history)
+	}
+}
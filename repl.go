@@ -0,0 +1,208 @@
+package readline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ergochat/readline/internal/runes"
+)
+
+// CommandHandler runs a command of a PrefixCompleter tree; args are the
+// words of the line after those that name the command.
+type CommandHandler func(args []string) error
+
+// ErrStopREPL can be returned by a CommandHandler to end Instance.RunREPL.
+var ErrStopREPL = errors.New("stop REPL")
+
+// UnknownCommandError is returned by PrefixCompleter.Dispatch when the line
+// does not name a command of the tree.
+type UnknownCommandError struct {
+	// Command is the words of the line, up to the first unknown word.
+	Command string
+	// Suggestion is the known command closest to Command, or "".
+	Suggestion string
+}
+
+func (e *UnknownCommandError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("unknown command %q, did you mean %q?", e.Command, e.Suggestion)
+	}
+	return fmt.Sprintf("unknown command %q", e.Command)
+}
+
+// NoHandlerError is returned by PrefixCompleter.Dispatch when the line names
+// a command that exists in the tree but has no Handler, such as a group of
+// subcommands.
+type NoHandlerError struct {
+	// Command is the words of the line that name the command.
+	Command string
+	// Subcommands is the names of the children of the command, if any.
+	Subcommands []string
+}
+
+func (e *NoHandlerError) Error() string {
+	if len(e.Subcommands) != 0 {
+		return fmt.Sprintf("command %q requires a subcommand: %s", e.Command, strings.Join(e.Subcommands, ", "))
+	}
+	return fmt.Sprintf("command %q has no handler", e.Command)
+}
+
+// splitLine splits a line into unquoted words, like Tokenize.
+func splitLine(line string) (words []string) {
+	r := []rune(line)
+	for _, w := range splitWords(r) {
+		words = append(words, string(unquoteWord(r[w.start:w.end])))
+	}
+	return
+}
+
+// child returns the child of p named word, ignoring the children that
+// complete arbitrary words (with Callback or Completer).
+func (p *PrefixCompleter) child(word string, fold bool) *PrefixCompleter {
+	for _, child := range p.Children {
+		if child.Callback != nil || child.Completer != nil {
+			continue
+		}
+		if name := strings.TrimSpace(child.Name); name == word || (fold && strings.EqualFold(name, word)) {
+			return child
+		}
+	}
+	return nil
+}
+
+// Dispatch runs the command named by a line: the line is split into words
+// like a shell would, and the words are matched against the names of the
+// nodes of the tree (ignoring case if Match is not MatchPrefix). The Handler
+// of the deepest matching node that has one is called with the remaining
+// words; nodes with a Callback or a Completer match arguments, so they are
+// not part of the path. If no node has a Handler, a NoHandlerError is
+// returned if the words name a node of the tree, and otherwise an
+// UnknownCommandError, suggesting the closest name to the first unknown
+// word. Empty lines are ignored.
+func (p *PrefixCompleter) Dispatch(line string) error {
+	words := splitLine(line)
+	if len(words) == 0 {
+		return nil
+	}
+	fold := p.Match != MatchPrefix
+	node, handler, handlerDepth := p, p.Handler, 0
+	depth := 0
+	for ; depth < len(words); depth++ {
+		child := node.child(words[depth], fold)
+		if child == nil {
+			break
+		}
+		node = child
+		if node.Handler != nil {
+			handler, handlerDepth = node.Handler, depth+1
+		}
+	}
+	if handler != nil {
+		return handler(words[handlerDepth:])
+	}
+	subcommands := node.subcommands()
+	if depth > 0 && (depth == len(words) || len(subcommands) == 0) {
+		return &NoHandlerError{Command: strings.Join(words[:depth], " "), Subcommands: subcommands}
+	}
+
+	err := &UnknownCommandError{Command: strings.Join(words[:depth], " ")}
+	if depth < len(words) {
+		err.Command = strings.Join(words[:depth+1], " ")
+		if closest := node.closestChild(words[depth]); closest != "" {
+			err.Suggestion = strings.Join(append(words[:depth:depth], closest), " ")
+		}
+	}
+	return err
+}
+
+// subcommands returns the names of the children of p, ignoring the children
+// that complete arbitrary words.
+func (p *PrefixCompleter) subcommands() (names []string) {
+	for _, child := range p.Children {
+		if child.Callback == nil && child.Completer == nil {
+			names = append(names, strings.TrimSpace(child.Name))
+		}
+	}
+	return
+}
+
+// closestChild returns the name of the child closest to word by edit
+// distance, if it is close enough to be a likely typo.
+func (p *PrefixCompleter) closestChild(word string) (closest string) {
+	best := len([]rune(word))/2 + 1
+	for _, child := range p.Children {
+		if child.Callback != nil || child.Completer != nil {
+			continue
+		}
+		name := strings.TrimSpace(child.Name)
+		if d := runes.EditDistance([]rune(word), []rune(name), true); d < best {
+			closest, best = name, d
+		}
+	}
+	return
+}
+
+// Help returns the tree of the commands under the node named by path (like
+// Tree, with descriptions), or "" if there is no such node.
+func (p *PrefixCompleter) Help(path ...string) string {
+	node := p
+	for _, word := range path {
+		if node = node.child(word, p.Match != MatchPrefix); node == nil {
+			return ""
+		}
+	}
+	return node.Tree("")
+}
+
+// RunREPL reads lines and runs them with commands.Dispatch, until EOF, or
+// until a handler returns ErrStopREPL, or ctx is done (in which case the line
+// being edited is abandoned, and ctx.Err() is returned). Errors returned by
+// the handlers are printed to Stderr, and Ctrl-C discards the current line.
+// Unless commands has a "help" node, "help [command...]" prints the help for
+// the commands (see Help) to Stdout. Config.AutoComplete should typically be
+// set to commands as well.
+func (i *Instance) RunREPL(ctx context.Context, commands *PrefixCompleter) error {
+	deadline := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			close(deadline)
+		case <-done:
+		}
+	}()
+
+	for {
+		line, err := i.operation.runesWithDeadline(deadline)
+		switch {
+		case err == ErrInterrupt:
+			continue
+		case err == io.EOF:
+			return nil
+		case err == deadlineExceeded:
+			return ctx.Err()
+		case err != nil:
+			return err
+		}
+
+		if words := splitLine(string(line)); len(words) != 0 && words[0] == "help" && commands.child("help", false) == nil {
+			if help := commands.Help(words[1:]...); help != "" {
+				io.WriteString(i.Stdout(), help)
+			} else {
+				fmt.Fprintf(i.Stderr(), "%v\n", &UnknownCommandError{Command: strings.Join(words[1:], " ")})
+			}
+		} else if err := commands.Dispatch(string(line)); err == ErrStopREPL {
+			return nil
+		} else if err != nil {
+			fmt.Fprintf(i.Stderr(), "%v\n", err)
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
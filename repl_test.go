@@ -0,0 +1,109 @@
+package readline
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCommands(calls *[][]string) *PrefixCompleter {
+	handler := func(name string) CommandHandler {
+		return func(args []string) error {
+			*calls = append(*calls, append([]string{name}, args...))
+			return nil
+		}
+	}
+	status := PcItem("status")
+	status.Handler = handler("status")
+	status.Description = "show the status"
+	add := PcItem("add")
+	add.Handler = handler("add")
+	add.Description = "add a remote"
+	open := PcItem("open", PcItemDynamic(func(string) []string { return []string{"a.txt"} }))
+	open.Handler = handler("open")
+	quit := PcItem("quit")
+	quit.Handler = func([]string) error { return ErrStopREPL }
+	return NewPrefixCompleter(status, PcItem("remote", add, PcItem("remove")), open, quit)
+}
+
+func TestDispatch(t *testing.T) {
+	var calls [][]string
+	commands := newTestCommands(&calls)
+	testCases := []struct {
+		line     string
+		expected []string
+		err      error
+	}{
+		{"status a b", []string{"status", "a", "b"}, nil},
+		{"remote add origin 'x y'", []string{"add", "origin", "x y"}, nil},
+		{"open a.txt --force", []string{"open", "a.txt", "--force"}, nil},
+		{"  ", nil, nil},
+		{"stauts", nil, &UnknownCommandError{Command: "stauts", Suggestion: "status"}},
+		{"remote ad x", nil, &UnknownCommandError{Command: "remote ad", Suggestion: "remote add"}},
+		{"remote", nil, &NoHandlerError{Command: "remote", Subcommands: []string{"add", "remove"}}},
+		{"remote remove origin", nil, &NoHandlerError{Command: "remote remove"}},
+		{"xyz", nil, &UnknownCommandError{Command: "xyz"}},
+	}
+	for _, tc := range testCases {
+		calls = nil
+		err := commands.Dispatch(tc.line)
+		if tc.err != nil {
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("dispatching %q: expected error %v, got %v", tc.line, tc.err, err)
+			}
+		} else if err != nil {
+			t.Errorf("dispatching %q: unexpected error %v", tc.line, err)
+		}
+		var call []string
+		if len(calls) != 0 {
+			call = calls[0]
+		}
+		if !reflect.DeepEqual(call, tc.expected) {
+			t.Errorf("dispatching %q: expected call %q, got %q", tc.line, tc.expected, calls)
+		}
+	}
+	if err := commands.Dispatch("stauts"); err.Error() != `unknown command "stauts", did you mean "status"?` {
+		t.Errorf("unexpected message: %v", err)
+	}
+	if err := commands.Dispatch("remote"); err.Error() != `command "remote" requires a subcommand: add, remove` {
+		t.Errorf("unexpected message: %v", err)
+	}
+	if err := commands.Dispatch("remote remove"); err.Error() != `command "remote remove" has no handler` {
+		t.Errorf("unexpected message: %v", err)
+	}
+}
+
+func TestRunREPL(t *testing.T) {
+	var calls [][]string
+	commands := newTestCommands(&calls)
+	var stdout, stderr bytes.Buffer
+	input := "status\r" + "help remote\r" + "stauts\r" + "quit\r" + "status\r"
+	rl := newTestInstance(t, &Config{AutoComplete: commands, Stdout: &stdout, Stderr: &stderr}, input)
+	if err := rl.RunREPL(context.Background(), commands); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(calls, [][]string{{"status"}}) {
+		t.Errorf("unexpected calls: %q", calls)
+	}
+	if !strings.Contains(stdout.String(), "remote \n├── add  add a remote\n├── remove \n") {
+		t.Errorf("help not displayed: %q", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), `did you mean "status"?`) {
+		t.Errorf("error not displayed: %q", stderr.String())
+	}
+
+	// the line being edited is abandoned when the context is done:
+	stdin, input2 := io.Pipe()
+	defer input2.Close()
+	rl = newTestInstanceFromReader(t, &Config{}, stdin)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	go io.WriteString(input2, "stat")
+	if err := rl.RunREPL(ctx, commands); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
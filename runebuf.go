@@ -21,6 +21,10 @@ type runeBuffer struct {
 
 	lastKill []rune
 
+	// suggestion is an autosuggestion displayed after the end of the buffer;
+	// it is only set while the cursor is at the end of the buffer.
+	suggestion []rune
+
 	sync.Mutex
 }
 
@@ -120,11 +124,21 @@ func (r *runeBuffer) prompt() string {
 	return r.getConfig().Prompt
 }
 
+// contPrompt returns the prompt displayed at the start of each line that
+// follows a newline in the buffer.
+func (r *runeBuffer) contPrompt() string {
+	return r.getConfig().ContinuationPrompt
+}
+
+func (r *runeBuffer) contPromptLen() int {
+	return runes.WidthAll(runes.ColorFilter([]rune(r.contPrompt())))
+}
+
 func (r *runeBuffer) WriteRunes(s []rune) {
 	r.Lock()
 	defer r.Unlock()
 
-	if r.idx == len(r.buf) {
+	if r.idx == len(r.buf) && len(r.suggestion) == 0 {
 		// cursor is already at end of buf data so just call
 		// append instead of refesh to save redrawing.
 		r.buf = append(r.buf, s...)
@@ -138,10 +152,80 @@ func (r *runeBuffer) WriteRunes(s []rune) {
 			tail := append(s, r.buf[r.idx:]...)
 			r.buf = append(r.buf[:r.idx], tail...)
 			r.idx += len(s)
+			// keep the rest of the suggestion if it was typed out
+			if runes.HasPrefix(r.suggestion, s) {
+				r.suggestion = r.suggestion[len(s):]
+			} else {
+				r.suggestion = nil
+			}
 		})
 	}
 }
 
+// ReplaceRange replaces the runes between start and end with text, and
+// moves the cursor after it.
+func (r *runeBuffer) ReplaceRange(start, end int, text []rune) {
+	r.Refresh(func() {
+		tail := runes.Copy(r.buf[end:])
+		r.buf = append(append(r.buf[:start], text...), tail...)
+		r.idx = start + len(text)
+		r.suggestion = nil
+	})
+}
+
+// SetSuggestion sets the autosuggestion displayed after the buffer,
+// returning whether it changed (in which case the line was redrawn).
+func (r *runeBuffer) SetSuggestion(suggestion []rune) (changed bool) {
+	r.Lock()
+	defer r.Unlock()
+	if runes.Equal(r.suggestion, suggestion) {
+		return false
+	}
+	r.refresh(func() {
+		r.suggestion = runes.Copy(suggestion)
+	})
+	return true
+}
+
+// HasSuggestion returns whether an autosuggestion is displayed.
+func (r *runeBuffer) HasSuggestion() bool {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.suggestion) != 0
+}
+
+// AcceptSuggestion moves the autosuggestion into the buffer; if word is
+// true, only its first word is accepted.
+func (r *runeBuffer) AcceptSuggestion(word bool) {
+	r.Refresh(func() {
+		n := len(r.suggestion)
+		if word {
+			i := 0
+			for i < n && runes.IsWordBreak(r.suggestion[i]) {
+				i++
+			}
+			for i < n && !runes.IsWordBreak(r.suggestion[i]) {
+				i++
+			}
+			n = i
+		}
+		r.buf = append(r.buf, r.suggestion[:n]...)
+		r.idx = len(r.buf)
+		r.suggestion = r.suggestion[n:]
+	})
+}
+
+// displayRunes returns the runes displayed after the prompt: the buffer,
+// followed by the autosuggestion.
+func (r *runeBuffer) displayRunes() []rune {
+	if len(r.suggestion) == 0 {
+		return r.buf
+	}
+	rs := make([]rune, 0, len(r.buf)+len(r.suggestion))
+	rs = append(rs, r.buf...)
+	return append(rs, r.suggestion...)
+}
+
 func (r *runeBuffer) MoveForward() {
 	r.Refresh(func() {
 		if r.idx == len(r.buf) {
@@ -344,6 +428,66 @@ func (r *runeBuffer) Backspace() {
 	})
 }
 
+// MoveToPrevLine moves the cursor to the same column of the previous line,
+// in a buffer containing newlines; it returns false if the cursor is on the
+// first line.
+func (r *runeBuffer) MoveToPrevLine() bool {
+	r.Lock()
+	defer r.Unlock()
+	start := lineStart(r.buf, r.idx)
+	if start == 0 {
+		return false
+	}
+	r.refresh(func() {
+		col := r.idx - start
+		prevStart := lineStart(r.buf, start-1)
+		r.idx = start - 1 // the end of the previous line
+		if prevStart+col < r.idx {
+			r.idx = prevStart + col
+		}
+	})
+	return true
+}
+
+// MoveToNextLine moves the cursor to the same column of the next line, in
+// a buffer containing newlines; it returns false if the cursor is on the
+// last line.
+func (r *runeBuffer) MoveToNextLine() bool {
+	r.Lock()
+	defer r.Unlock()
+	end := lineEnd(r.buf, r.idx)
+	if end == len(r.buf) {
+		return false
+	}
+	r.refresh(func() {
+		col := r.idx - lineStart(r.buf, r.idx)
+		nextStart := end + 1
+		r.idx = lineEnd(r.buf, nextStart)
+		if nextStart+col < r.idx {
+			r.idx = nextStart + col
+		}
+	})
+	return true
+}
+
+// lineStart returns the index of the start of the line of buf containing
+// idx, i.e. after the previous newline.
+func lineStart(buf []rune, idx int) int {
+	for idx > 0 && buf[idx-1] != '\n' {
+		idx--
+	}
+	return idx
+}
+
+// lineEnd returns the index of the end of the line of buf containing idx,
+// i.e. of the next newline, or len(buf).
+func lineEnd(buf []rune, idx int) int {
+	for idx < len(buf) && buf[idx] != '\n' {
+		idx++
+	}
+	return idx
+}
+
 func (r *runeBuffer) MoveToLineEnd() {
 	r.Lock()
 	defer r.Unlock()
@@ -357,7 +501,7 @@ func (r *runeBuffer) MoveToLineEnd() {
 
 // LineCount returns number of lines the buffer takes as it appears in the terminal.
 func (r *runeBuffer) LineCount() int {
-	sp := r.getSplitByLine(r.buf, 1)
+	sp := r.getSplitByLine(r.displayRunes(), 1)
 	return len(sp)
 }
 
@@ -391,7 +535,7 @@ func (r *runeBuffer) MoveTo(ch rune, prevChar, reverse bool) (success bool) {
 }
 
 func (r *runeBuffer) isInLineEdge() bool {
-	sp := r.getSplitByLine(r.buf, 1)
+	sp := r.getSplitByLine(r.displayRunes(), 1)
 	return len(sp[len(sp)-1]) == 0 // last line is 0 len
 }
 
@@ -403,7 +547,7 @@ func (r *runeBuffer) getSplitByLine(rs []rune, nextWidth int) [][]rune {
 		masked := []rune(strings.Repeat(string(cfg.MaskRune), len(rs)))
 		return runes.SplitByLine(runes.ColorFilter([]rune(r.prompt())), masked, r.ppos, tWidth, w)
 	} else {
-		return runes.SplitByLine(runes.ColorFilter([]rune(r.prompt())), rs, r.ppos, tWidth, nextWidth)
+		return runes.SplitByLineCont(runes.ColorFilter([]rune(r.prompt())), rs, r.ppos, r.contPromptLen(), tWidth, nextWidth)
 	}
 }
 
@@ -533,24 +677,43 @@ func (r *runeBuffer) output() []byte {
 		for _, e := range cfg.Painter(r.buf, r.idx) {
 			if e == '\t' {
 				buf.WriteString(strings.Repeat(" ", runes.TabWidth))
+			} else if e == '\n' {
+				buf.WriteRune(e)
+				buf.WriteString(r.contPrompt())
 			} else {
 				buf.WriteRune(e)
 			}
 		}
+		if len(r.suggestion) != 0 {
+			buf.WriteString("\033[90m")
+			for _, e := range r.suggestion {
+				if e == '\t' {
+					buf.WriteString(strings.Repeat(" ", runes.TabWidth))
+				} else if e == '\n' {
+					// the line splitting assumes a continuation prompt
+					buf.WriteRune(e)
+					buf.WriteString("\033[0m" + r.contPrompt() + "\033[90m")
+				} else {
+					buf.WriteRune(e)
+				}
+			}
+			buf.WriteString("\033[0m")
+		}
 	}
 	if r.isInLineEdge() {
 		buf.WriteString(" \b")
 	}
 	// cursor position
-	if len(r.buf) > r.idx {
+	if len(r.buf)+len(r.suggestion) > r.idx {
 		buf.Write(r.getBackspaceSequence())
 	}
 	return buf.Bytes()
 }
 
 func (r *runeBuffer) getBackspaceSequence() []byte {
-	bcnt := len(r.buf) - r.idx // backwards count to index
-	sp := r.getSplitByLine(r.buf, 1)
+	display := r.displayRunes()
+	bcnt := len(display) - r.idx // backwards count to index
+	sp := r.getSplitByLine(display, 1)
 
 	// Calculate how many lines up to the index line
 	up := 0
@@ -568,6 +731,8 @@ func (r *runeBuffer) getBackspaceSequence() []byte {
 	column := 1
 	if spi == 0 {
 		column += r.ppos
+	} else if prev := sp[spi-1]; prev[len(prev)-1] == '\n' {
+		column += r.contPromptLen()
 	}
 	for _, rune := range sp[spi] {
 		if bcnt >= 0 {
@@ -597,12 +762,14 @@ func (r *runeBuffer) CopyForUndo(prev []rune) (cur []rune, idx int, changed bool
 func (r *runeBuffer) Restore(buf []rune, idx int) {
 	r.buf = buf
 	r.idx = idx
+	r.suggestion = nil
 }
 
 func (r *runeBuffer) Reset() []rune {
 	ret := runes.Copy(r.buf)
 	r.buf = r.buf[:0]
 	r.idx = 0
+	r.suggestion = nil
 	return ret
 }
 
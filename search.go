@@ -4,7 +4,12 @@ import (
 	"bytes"
 	"container/list"
 	"fmt"
+	"regexp"
 	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ergochat/readline/internal/runes"
 )
 
 type searchState uint
@@ -21,16 +26,36 @@ const (
 	searchDirectionBackward
 )
 
+// searchMatch is an occurrence of the search pattern in a history entry
+type searchMatch struct {
+	elem    *list.Element
+	ordinal int // position of elem in the history, counting back from the last entry
+	start   int // index of the first rune of the match in the entry
+	end     int // index after the last rune of the match in the entry
+}
+
+// before returns whether m comes before (i.e. is more recent than)
+// a match in the history entry `ordinal` starting at index `start`.
+func (m *searchMatch) before(ordinal, start int) bool {
+	if m.ordinal != ordinal {
+		return m.ordinal < ordinal
+	}
+	return m.start > start
+}
+
 type opSearch struct {
 	mutex     sync.Mutex
 	inMode    bool
 	state     searchState
 	dir       searchDirection
+	regex     bool
 	source    *list.Element
 	w         *terminal
 	buf       *runeBuffer
 	data      []rune
 	history   *opHistory
+	matches   []searchMatch // ordered from most to least recent, and right to left within an entry
+	choice    int           // index in matches of the current match, or -1
 	markStart int
 	markEnd   int
 }
@@ -40,7 +65,25 @@ func newOpSearch(w *terminal, buf *runeBuffer, history *opHistory) *opSearch {
 		w:       w,
 		buf:     buf,
 		history: history,
+		choice:  -1,
+	}
+}
+
+// historySearchFold returns whether history search for pattern should be
+// case-insensitive.
+func historySearchFold(cfg *Config, pattern []rune) bool {
+	if cfg.HistorySearchFold {
+		return true
+	}
+	if cfg.HistorySearchSmartCase {
+		for _, r := range pattern {
+			if unicode.IsUpper(r) {
+				return false
+			}
+		}
+		return true
 	}
+	return false
 }
 
 func (o *opSearch) IsSearchMode() bool {
@@ -54,42 +97,148 @@ func (o *opSearch) SearchBackspace() {
 	defer o.mutex.Unlock()
 	if len(o.data) > 0 {
 		o.data = o.data[:len(o.data)-1]
-		o.search(true)
+		o.search()
 	}
 }
 
-func (o *opSearch) findHistoryBy(isNewSearch bool) (int, *list.Element) {
-	if o.dir == searchDirectionBackward {
-		return o.history.FindBck(isNewSearch, o.data, o.buf.idx)
+// findMatches computes all the matches of the pattern in the history.
+func (o *opSearch) findMatches() (valid bool) {
+	o.matches = o.matches[:0]
+	fold := historySearchFold(o.history.operation.GetConfig(), o.data)
+	var re *regexp.Regexp
+	if o.regex {
+		pattern := string(o.data)
+		if fold {
+			pattern = "(?i)" + pattern
+		}
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			return false
+		}
 	}
-	return o.history.FindFwd(isNewSearch, o.data, o.buf.idx)
+
+	ordinal := 0
+	for elem := o.history.history.Back(); elem != nil; elem = elem.Prev() {
+		if !o.history.isVisible(elem) {
+			ordinal++
+			continue
+		}
+		item := o.history.showItem(elem.Value)
+		first := len(o.matches)
+		if re != nil {
+			str := string(item)
+			for _, loc := range re.FindAllStringIndex(str, -1) {
+				if loc[0] == loc[1] {
+					continue // ignore empty matches
+				}
+				start := utf8.RuneCountInString(str[:loc[0]])
+				end := start + utf8.RuneCountInString(str[loc[0]:loc[1]])
+				o.matches = append(o.matches, searchMatch{elem: elem, ordinal: ordinal, start: start, end: end})
+			}
+		} else {
+			for offset := 0; offset < len(item); {
+				idx := runes.IndexAllEx(item[offset:], o.data, fold)
+				if idx < 0 {
+					break
+				}
+				start := offset + idx
+				o.matches = append(o.matches, searchMatch{elem: elem, ordinal: ordinal, start: start, end: start + len(o.data)})
+				offset = start + len(o.data)
+			}
+		}
+		// reverse the matches within this entry, so that they go right to left
+		for i, j := first, len(o.matches)-1; i < j; i, j = i+1, j-1 {
+			o.matches[i], o.matches[j] = o.matches[j], o.matches[i]
+		}
+		ordinal++
+	}
+	return true
 }
 
-func (o *opSearch) search(isChange bool) bool {
+// search recomputes the matches after a change to the pattern, then
+// continues the search from the current position.
+func (o *opSearch) search() {
 	if len(o.data) == 0 {
+		o.matches = o.matches[:0]
+		o.choice = -1
 		o.state = searchStateFound
-		o.searchRefresh(-1)
-		return true
+		o.searchRefresh()
+		return
 	}
-	idx, elem := o.findHistoryBy(isChange)
-	if elem == nil {
-		o.searchRefresh(-2)
-		return false
+
+	// the search continues from the current match, if any, or else
+	// from the cursor position in the current entry:
+	var ordinal, start int
+	if o.choice >= 0 && o.choice < len(o.matches) {
+		ordinal, start = o.matches[o.choice].ordinal, o.matches[o.choice].start
+	} else {
+		for elem := o.history.history.Back(); elem != nil && elem != o.history.current; elem = elem.Prev() {
+			ordinal++
+		}
+		start = o.buf.idx
 	}
-	o.history.current = elem
 
-	item := o.history.showItem(o.history.current.Value)
-	start, end := 0, 0
+	o.choice = -1
+	if o.findMatches() {
+		if o.dir == searchDirectionBackward {
+			for i := range o.matches {
+				if !o.matches[i].before(ordinal, start) {
+					o.choice = i
+					break
+				}
+			}
+		} else {
+			for i := len(o.matches) - 1; i >= 0; i-- {
+				if o.matches[i].ordinal < ordinal || (o.matches[i].ordinal == ordinal && o.matches[i].start >= start) {
+					o.choice = i
+					break
+				}
+			}
+		}
+	}
+	o.showChoice()
+}
+
+// next moves to the next match in the search direction.
+func (o *opSearch) next() {
+	if len(o.data) == 0 || len(o.matches) == 0 {
+		o.searchRefresh()
+		return
+	}
+	choice := o.choice
 	if o.dir == searchDirectionBackward {
-		start, end = idx, idx+len(o.data)
+		choice++
 	} else {
-		start, end = idx, idx+len(o.data)
-		idx += len(o.data)
+		choice--
+	}
+	if choice < 0 || choice >= len(o.matches) {
+		// no more matches: stay on the current one
+		o.state = searchStateFailing
+		o.searchRefresh()
+		return
+	}
+	o.choice = choice
+	o.showChoice()
+}
+
+// showChoice displays the current match in the buffer.
+func (o *opSearch) showChoice() {
+	if o.choice < 0 {
+		o.state = searchStateFailing
+		o.searchRefresh()
+		return
+	}
+	o.state = searchStateFound
+	m := o.matches[o.choice]
+	o.history.current = m.elem
+	item := o.history.showItem(m.elem.Value)
+	idx := m.start
+	if o.dir == searchDirectionForward {
+		idx = m.end
 	}
 	o.buf.SetWithIdx(idx, item)
-	o.markStart, o.markEnd = start, end
-	o.searchRefresh(idx)
-	return true
+	o.markStart, o.markEnd = m.start, m.end
+	o.searchRefresh()
 }
 
 func (o *opSearch) SearchChar(r rune) {
@@ -97,7 +246,16 @@ func (o *opSearch) SearchChar(r rune) {
 	defer o.mutex.Unlock()
 
 	o.data = append(o.data, r)
-	o.search(true)
+	o.search()
+}
+
+// ToggleRegex switches between literal and regular expression matching.
+func (o *opSearch) ToggleRegex() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.regex = !o.regex
+	o.search()
 }
 
 func (o *opSearch) SearchMode(dir searchDirection) bool {
@@ -111,11 +269,11 @@ func (o *opSearch) SearchMode(dir searchDirection) bool {
 	alreadyInMode := o.inMode
 	o.inMode = true
 	o.dir = dir
-	o.source = o.history.current
 	if alreadyInMode {
-		o.search(false)
+		o.next()
 	} else {
-		o.searchRefresh(-1)
+		o.source = o.history.current
+		o.searchRefresh()
 	}
 	return true
 }
@@ -135,25 +293,20 @@ func (o *opSearch) ExitSearchMode(revert bool) {
 	o.markStart, o.markEnd = 0, 0
 	o.state = searchStateFound
 	o.inMode = false
+	o.regex = false
 	o.source = nil
 	o.data = nil
+	o.matches = nil
+	o.choice = -1
 }
 
-func (o *opSearch) searchRefresh(x int) {
+func (o *opSearch) searchRefresh() {
 	tWidth, _ := o.w.GetWidthHeight()
-	if x == -2 {
-		o.state = searchStateFailing
-	} else if x >= 0 {
-		o.state = searchStateFound
-	}
-	if x < 0 {
-		x = o.buf.idx
-	}
-	x = o.buf.CurrentWidth(x)
+	x := o.buf.CurrentWidth(o.buf.idx)
 	x += o.buf.PromptLen()
 	x = x % tWidth
 
-	if o.markStart > 0 {
+	if o.markEnd > o.markStart {
 		o.buf.SetStyle(o.markStart, o.markEnd, "4")
 	}
 
@@ -164,12 +317,19 @@ func (o *opSearch) searchRefresh(x int) {
 	if o.state == searchStateFailing {
 		buf.WriteString("failing ")
 	}
+	if o.regex {
+		buf.WriteString("regex ")
+	}
 	if o.dir == searchDirectionBackward {
 		buf.WriteString("bck")
 	} else if o.dir == searchDirectionForward {
 		buf.WriteString("fwd")
 	}
-	buf.WriteString("-i-search: ")
+	buf.WriteString("-i-search")
+	if len(o.data) != 0 {
+		fmt.Fprintf(buf, " (%d/%d)", o.choice+1, len(o.matches))
+	}
+	buf.WriteString(": ")
 	buf.WriteString(string(o.data))         // keyword
 	buf.WriteString("\033[4m \033[0m")      // _
 	fmt.Fprintf(buf, "\r\033[%dA", lineCnt) // move prev
@@ -184,6 +344,6 @@ func (o *opSearch) RefreshIfNeeded() {
 	defer o.mutex.Unlock()
 
 	if o.inMode {
-		o.searchRefresh(-1)
+		o.searchRefresh()
 	}
 }
@@ -0,0 +1,49 @@
+package readline
+
+import (
+	"container/list"
+
+	"github.com/ergochat/readline/internal/runes"
+)
+
+// Suggester provides fish-style autosuggestions (see Config.AutoSuggest).
+type Suggester interface {
+	// Readline will pass the whole line and current offset to it; the
+	// returned runes are displayed after the end of the line, and can be
+	// accepted by the user. Suggest is only called when the cursor is at
+	// the end of a non-empty line.
+	Suggest(line []rune, pos int) (suggestion []rune)
+}
+
+// NewCompleterSuggester returns a Suggester that suggests the completion
+// of the line that is common to all the candidates of completer.
+func NewCompleterSuggester(completer AutoCompleter) Suggester {
+	return &completerSuggester{completer: completer}
+}
+
+type completerSuggester struct {
+	completer AutoCompleter
+}
+
+func (c *completerSuggester) Suggest(line []rune, pos int) []rune {
+	candidates, _ := c.completer.Do(line, pos)
+	if len(candidates) == 0 {
+		return nil
+	}
+	same, _ := runes.Aggregate(candidates)
+	return same
+}
+
+// suggest returns the remainder of the most recent history entry that
+// starts with line, for use as the default Suggester.
+func (o *opHistory) suggest(line []rune) (suggestion []rune) {
+	o.iterate(func(elem *list.Element) bool {
+		entry := elem.Value.(*hisItem).Source
+		if len(entry) > len(line) && runes.HasPrefix(entry, line) {
+			suggestion = runes.Copy(entry[len(line):])
+			return true
+		}
+		return false
+	})
+	return
+}
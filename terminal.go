@@ -326,6 +326,10 @@ func (t *terminal) consumeANSIEscape(buf *bufio.Reader, ansiBuf *bytes.Buffer) (
 	case 'b':
 		// Alt-b in xterm, or Option+LeftArrow in iTerm2 with "Natural text editing"
 		return readResult{r: MetaBackward, ok: true}, nil // Alt-b
+	case 'r':
+		return readResult{r: MetaToggleRegex, ok: true}, nil // Alt-r
+	case '.', '_':
+		return readResult{r: MetaYankLastArg, ok: true}, nil // Alt-. or Alt-_
 	case '[', 'O':
 		// this is a real ANSI escape sequence, read the rest of the sequence below:
 	case '\x1b':
@@ -390,6 +394,10 @@ func (t *terminal) consumeANSIEscape(buf *bufio.Reader, ansiBuf *bytes.Buffer) (
 				r = CharLineStart // "Home" key
 			case "4", "8":
 				r = CharLineEnd // "End" key
+			case "5":
+				r = MetaPageUp
+			case "6":
+				r = MetaPageDown
 			}
 		}
 	case 'Z':
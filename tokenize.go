@@ -0,0 +1,156 @@
+package readline
+
+import (
+	"strings"
+	"unicode"
+)
+
+// shellWord is a word of a command line, as returned by splitWords.
+type shellWord struct {
+	start int // index in the line of the first rune of the word
+	end   int // index in the line after the last rune of the word
+}
+
+// splitWords splits line into words separated by unquoted whitespace,
+// honoring single quotes, double quotes and backslash escapes the way a
+// POSIX shell does. An unterminated quote extends to the end of the line.
+func splitWords(line []rune) (words []shellWord) {
+	start := -1
+	var quote rune
+	for i := 0; i < len(line); i++ {
+		r := line[i]
+		if start == -1 {
+			if unicode.IsSpace(r) {
+				continue
+			}
+			start = i
+		}
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			}
+		case r == '\\':
+			i++ // skip the escaped rune
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case unicode.IsSpace(r):
+			words = append(words, shellWord{start: start, end: i})
+			start = -1
+		}
+	}
+	if start != -1 {
+		words = append(words, shellWord{start: start, end: len(line)})
+	}
+	return
+}
+
+// wordStart returns the index in line of the start of the word ending at
+// pos (or pos, if there is none), along with the words before it.
+func wordStart(line []rune, pos int) (start int, before []shellWord) {
+	words := splitWords(line[:pos])
+	if len(words) != 0 && words[len(words)-1].end == pos {
+		return words[len(words)-1].start, words[:len(words)-1]
+	}
+	return pos, words
+}
+
+// wordEnd returns the index in line of the end of the word that the cursor
+// at pos is in the middle of (or pos, if there is none).
+func wordEnd(line []rune, pos int) int {
+	for _, w := range splitWords(line) {
+		if w.start < pos && pos < w.end {
+			return w.end
+		}
+	}
+	return pos
+}
+
+// wordQuote returns the quote rune with which word starts, if any.
+func wordQuote(word []rune) rune {
+	if len(word) != 0 && (word[0] == '\'' || word[0] == '"') {
+		return word[0]
+	}
+	return 0
+}
+
+// unquoteWord removes the quotes and backslash escapes from a word (as
+// returned by splitWords); an unterminated quote extends to the end of the
+// word.
+func unquoteWord(word []rune) []rune {
+	result := make([]rune, 0, len(word))
+	var quote rune
+	for i := 0; i < len(word); i++ {
+		r := word[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+				continue
+			}
+		case r == '\\':
+			if i+1 < len(word) {
+				i++
+				r = word[i]
+				// inside double quotes, a backslash only escapes some runes
+				if quote == '"' && !strings.ContainsRune("\\\"$`", r) {
+					result = append(result, '\\')
+				}
+			}
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+				continue
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			continue
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+// shellSpecialRunes are the runes that must be escaped or quoted in words.
+const shellSpecialRunes = " \t\n\\'\"$`&|;<>()*?[]!{}"
+
+// quoteWord quotes word so that splitWords and unquoteWord parse it back
+// as a single word: with the given quote rune (' or ") if it is non-zero,
+// or else by escaping the special runes with backslashes.
+func quoteWord(word []rune, quote rune) []rune {
+	result := make([]rune, 0, len(word)+2)
+	switch quote {
+	case '\'':
+		result = append(result, '\'')
+		for _, r := range word {
+			if r == '\'' {
+				// close the quote, escape the quote, reopen the quote
+				result = append(result, '\'', '\\', '\'', '\'')
+			} else {
+				result = append(result, r)
+			}
+		}
+		result = append(result, '\'')
+	case '"':
+		result = append(result, '"')
+		for _, r := range word {
+			if strings.ContainsRune("\\\"$`", r) {
+				result = append(result, '\\')
+			}
+			result = append(result, r)
+		}
+		result = append(result, '"')
+	default:
+		for _, r := range word {
+			if strings.ContainsRune(shellSpecialRunes, r) {
+				result = append(result, '\\')
+			}
+			result = append(result, r)
+		}
+	}
+	return result
+}
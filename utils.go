@@ -26,6 +26,7 @@ const (
 	CharCtrlL     = 12
 	CharEnter     = 13
 	CharNext      = 14
+	CharCtrlO     = 15
 	CharPrev      = 16
 	CharBckSearch = 18
 	CharFwdSearch = 19
@@ -49,6 +50,15 @@ const (
 	MetaTranspose
 	MetaShiftTab
 	MetaDeleteKey
+	MetaToggleRegex // Alt-r; toggles regular expressions in incremental search
+	MetaYankLastArg // Alt-. or Alt-_
+	MetaPageUp
+	MetaPageDown
+	// These runes are not produced by any key by default, but can be produced
+	// by FuncFilterInputRune to bind the corresponding command to a key:
+	MetaHistorySearchBackward // GNU Readline's history-search-backward
+	MetaHistorySearchForward  // GNU Readline's history-search-forward
+	MetaMagicSpace            // history expansion before the cursor, then a space
 )
 
 type rawModeHandler struct {